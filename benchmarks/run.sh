@@ -0,0 +1,19 @@
+#!/bin/sh
+# Runs every benchmark pair and prints the wall time of the typed fast path
+# next to the untyped path. Usage: benchmarks/run.sh [up binary]; pass
+# "up -vm" to measure the bytecode VM.
+set -e
+
+up=${1:-"go run ."}
+dir=$(dirname "$0")
+
+for typed in "$dir"/*_typed.up; do
+    name=$(basename "$typed" _typed.up)
+    untyped="$dir/${name}_untyped.up"
+    for file in "$typed" "$untyped"; do
+        start=$(date +%s%N)
+        $up -debug=false "$file" > /dev/null
+        end=$(date +%s%N)
+        printf '%-24s %8d ms\n' "$(basename "$file")" $(((end - start) / 1000000))
+    done
+done
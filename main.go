@@ -17,6 +17,13 @@ var options core.Options
 func parseOptions() {
 	flag.BoolVar(&options.Debug, "debug", true, "")
 	flag.BoolVar(&options.Compile, "compile", false, "")
+	flag.BoolVar(&options.Check, "check", false, "type check the program before running it")
+	flag.BoolVar(&options.VM, "vm", false, "compile the program to bytecode and run it on the VM")
+	flag.IntVar(&options.Procs, "procs", 0, "number of processors running up threads (default: one per CPU)")
+	flag.BoolVar(&options.Stats, "stats", false, "print scheduler statistics when the program ends")
+	flag.BoolVar(&options.Deterministic, "deterministic", false, "run threads one at a time in a seeded, reproducible order")
+	flag.Int64Var(&options.Seed, "seed", 0, "replay the deterministic schedule of a seed (implies -deterministic)")
+	flag.BoolVar(&options.Race, "race", false, "report variables shared by threads without synchronization")
 	flag.Parse()
 }
 
@@ -34,20 +41,20 @@ func getAttr(obj interface{}, fieldName string) (reflect.Value, error) {
 }
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("Usage: go run . <filename.up>")
-		for _, arg := range os.Args[1:] {
-			fmt.Println(getAttr(options, arg))
+	parseOptions()
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: go run . [-check] [-vm] [-procs n] [-stats] [-deterministic] [-seed n] [-race] <filename.up>")
+		for _, arg := range flag.Args() {
+			fmt.Println(getAttr(&options, arg))
 		}
 		return
 	}
-	parseOptions()
 	cwd, err := os.Getwd()
     if err != nil {
         fmt.Println("Error getting current directory:", err)
         return
     }
-	filename := os.Args[1]
+	filename := flag.Arg(0)
 	absolutePath := filepath.Join(cwd, filename)
 	fmt.Println(options)
 	if options.Compile {
@@ -1,6 +1,7 @@
 package up
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -9,6 +10,16 @@ type Node interface {
 	String() string
 }
 
+// Position is the place in the source a node was parsed from.
+type Position struct {
+	Row int
+	Col int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("[%d:%d]", p.Row, p.Col)
+}
+
 // Expressions
 type FloatNode struct {
 	Value float64
@@ -26,6 +37,20 @@ func (n *IntNode) String() string {
 	return strconv.Itoa(n.Value)
 }
 
+type BoolNode struct {
+	Value bool
+}
+
+func (n *BoolNode) String() string {
+	return strconv.FormatBool(n.Value)
+}
+
+type NilNode struct{}
+
+func (n *NilNode) String() string {
+	return "nil"
+}
+
 type StringNode struct {
 	Value string
 }
@@ -34,8 +59,37 @@ func (n *StringNode) String() string {
 	return `"` + n.Value + `"`
 }
 
+type ListNode struct {
+	Elements []Node
+	Pos      Position
+}
+
+func (n *ListNode) String() string {
+	elementStrs := []string{}
+	for _, element := range n.Elements {
+		elementStrs = append(elementStrs, element.String())
+	}
+	return "[" + strings.Join(elementStrs, ", ") + "]"
+}
+
+// MapNode is a map literal; Keys[i] maps to Values[i].
+type MapNode struct {
+	Keys   []Node
+	Values []Node
+	Pos    Position
+}
+
+func (n *MapNode) String() string {
+	entryStrs := []string{}
+	for i, key := range n.Keys {
+		entryStrs = append(entryStrs, key.String()+": "+n.Values[i].String())
+	}
+	return "{" + strings.Join(entryStrs, ", ") + "}"
+}
+
 type IdentifierNode struct {
 	Name string
+	Pos  Position
 }
 
 func (n *IdentifierNode) String() string {
@@ -45,15 +99,30 @@ func (n *IdentifierNode) String() string {
 type BinOpNode struct {
 	Left, Right Node
 	Op          string
+	Pos         Position
 }
 
 func (n *BinOpNode) String() string {
 	return "(" + n.Left.String() + " " + n.Op + " " + n.Right.String() + ")"
 }
 
+type UnaryOpNode struct {
+	Op      string
+	Operand Node
+	Pos     Position
+}
+
+func (n *UnaryOpNode) String() string {
+	return "(" + n.Op + n.Operand.String() + ")"
+}
+
+// FunctionCallNode calls a function by name, or, when Callee is set, calls
+// whatever function value the Callee expression evaluates to.
 type FunctionCallNode struct {
 	FunctionName string
+	Callee       Node
 	Arguments    []Node
+	Pos          Position
 }
 
 func (n *FunctionCallNode) String() string {
@@ -61,32 +130,194 @@ func (n *FunctionCallNode) String() string {
 	for _, arg := range n.Arguments {
 		args = append(args, arg.String())
 	}
-	return n.FunctionName + "(" + strings.Join(args, ", ") + ")"
+	return n.CalleeString() + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (n *FunctionCallNode) CalleeString() string {
+	if n.Callee != nil {
+		return n.Callee.String()
+	}
+	return n.FunctionName
+}
+
+// MethodCallNode calls Method on the value of Receiver, e.g. 1.sum(3).
+type MethodCallNode struct {
+	Receiver  Node
+	Method    string
+	Arguments []Node
+	Pos       Position
+}
+
+func (n *MethodCallNode) String() string {
+	args := []string{}
+	for _, arg := range n.Arguments {
+		args = append(args, arg.String())
+	}
+	return n.Receiver.String() + "." + n.Method + "(" + strings.Join(args, ", ") + ")"
+}
+
+// FieldAccessNode reads a field of an object, e.g. p.x.
+type FieldAccessNode struct {
+	Object Node
+	Field  string
+	Pos    Position
+}
+
+func (n *FieldAccessNode) String() string {
+	return n.Object.String() + "." + n.Field
+}
+
+// FieldAssignmentNode assigns Object.Field. Op is the operator of a
+// compound assignment like += and empty for a plain one.
+type FieldAssignmentNode struct {
+	Object Node
+	Field  string
+	Op     string
+	Value  Node
+	Pos    Position
+}
+
+func (n *FieldAssignmentNode) String() string {
+	return n.Object.String() + "." + n.Field + " " + n.Op + "= " + n.Value.String()
+}
+
+type IndexNode struct {
+	Object Node
+	Index  Node
+	Pos    Position
+}
+
+func (n *IndexNode) String() string {
+	return n.Object.String() + "[" + n.Index.String() + "]"
+}
+
+// SliceNode takes Object[Low:High]; either bound may be nil.
+type SliceNode struct {
+	Object Node
+	Low    Node
+	High   Node
+	Pos    Position
+}
+
+func (n *SliceNode) String() string {
+	low, high := "", ""
+	if n.Low != nil {
+		low = n.Low.String()
+	}
+	if n.High != nil {
+		high = n.High.String()
+	}
+	return n.Object.String() + "[" + low + ":" + high + "]"
+}
+
+// IndexAssignmentNode assigns Object[Index]. Op is the operator of a
+// compound assignment like += and empty for a plain one.
+type IndexAssignmentNode struct {
+	Object Node
+	Index  Node
+	Op     string
+	Value  Node
+	Pos    Position
+}
+
+func (n *IndexAssignmentNode) String() string {
+	return n.Object.String() + "[" + n.Index.String() + "] " + n.Op + "= " + n.Value.String()
+}
+
+type SendNode struct {
+	Stream Node
+	Value  Node
+	Pos    Position
+}
+
+func (n *SendNode) String() string {
+	return n.Stream.String() + " <- " + n.Value.String()
+}
+
+type ReceiveNode struct {
+	Stream Node
+	Pos    Position
+}
+
+func (n *ReceiveNode) String() string {
+	return "<-" + n.Stream.String()
 }
 
 type AssignmentNode struct {
 	VarName string
-	Type    string
+	Type    *TypeNode
 	Value   Node
+	Pos     Position
 }
 
 func (n *AssignmentNode) String() string {
-	return n.VarName + ": " + n.Type + " = " + n.Value.String()
+	if n.Type == nil {
+		return n.VarName + " = " + n.Value.String()
+	}
+	return n.VarName + ": " + n.Type.String() + " = " + n.Value.String()
 }
 
 // Statements
 type ReturnNode struct {
 	Value Node
+	Pos   Position
 }
 
 func (n *ReturnNode) String() string {
+	if n.Value == nil {
+		return "return"
+	}
 	return "return " + n.Value.String()
 }
 
+// IfNode keeps an `else if` chain as a single nested IfNode in Else.
+type IfNode struct {
+	Condition Node
+	Then      []Node
+	Else      []Node
+	Pos       Position
+}
+
+func (n *IfNode) String() string {
+	thenStrs := []string{}
+	for _, stmt := range n.Then {
+		thenStrs = append(thenStrs, stmt.String())
+	}
+	result := "if " + n.Condition.String() + " {\n\t" + strings.Join(thenStrs, "\n\t") + "\n}"
+	if len(n.Else) == 1 {
+		if elseIf, ok := n.Else[0].(*IfNode); ok {
+			return result + " else " + elseIf.String()
+		}
+	}
+	if n.Else != nil {
+		elseStrs := []string{}
+		for _, stmt := range n.Else {
+			elseStrs = append(elseStrs, stmt.String())
+		}
+		result += " else {\n\t" + strings.Join(elseStrs, "\n\t") + "\n}"
+	}
+	return result
+}
+
+type SpawnNode struct {
+	Call *FunctionCallNode
+	Pos  Position
+}
+
+func (n *SpawnNode) String() string {
+	return "up " + n.Call.String()
+}
+
+// ForLoopNode either counts with range(Range) or, when Range is nil,
+// walks the values produced by Iterable. With two variables, as in
+// for k, v in m, Variable gets the key or index and ValueVariable the value.
 type ForLoopNode struct {
-	Variable     string
-	Range        Node
-	Body         []Node
+	Variable      string
+	ValueVariable string
+	Range         Node
+	Iterable      Node
+	Body          []Node
+	Pos           Position
 }
 
 func (n *ForLoopNode) String() string {
@@ -94,46 +325,213 @@ func (n *ForLoopNode) String() string {
 	for _, stmt := range n.Body {
 		bodyStrs = append(bodyStrs, stmt.String())
 	}
-	return "for " + n.Variable + " in range(" + n.Range.String() + ") {\n\t" + strings.Join(bodyStrs, "\n\t") + "\n}"
+	var source string
+	if n.Range != nil {
+		source = "range(" + n.Range.String() + ")"
+	} else {
+		source = n.Iterable.String()
+	}
+	variables := n.Variable
+	if n.ValueVariable != "" {
+		variables += ", " + n.ValueVariable
+	}
+	return "for " + variables + " in " + source + " {\n\t" + strings.Join(bodyStrs, "\n\t") + "\n}"
+}
+
+// SelectCaseNode is a single `case` arm of a select. Comm is either a
+// *SendNode, a *ReceiveNode or an *AssignmentNode receiving into a variable.
+type SelectCaseNode struct {
+	Comm Node
+	Body []Node
+}
+
+func (n *SelectCaseNode) String() string {
+	bodyStrs := []string{}
+	for _, stmt := range n.Body {
+		bodyStrs = append(bodyStrs, stmt.String())
+	}
+	return "case " + n.Comm.String() + ":\n\t" + strings.Join(bodyStrs, "\n\t")
+}
+
+type SelectNode struct {
+	Cases       []*SelectCaseNode
+	HasDefault  bool
+	Default     []Node
+	Timeout     Node
+	TimeoutBody []Node
+	Pos         Position
+}
+
+func (n *SelectNode) String() string {
+	armStrs := []string{}
+	for _, c := range n.Cases {
+		armStrs = append(armStrs, c.String())
+	}
+	if n.HasDefault {
+		bodyStrs := []string{}
+		for _, stmt := range n.Default {
+			bodyStrs = append(bodyStrs, stmt.String())
+		}
+		armStrs = append(armStrs, "default:\n\t"+strings.Join(bodyStrs, "\n\t"))
+	}
+	if n.Timeout != nil {
+		bodyStrs := []string{}
+		for _, stmt := range n.TimeoutBody {
+			bodyStrs = append(bodyStrs, stmt.String())
+		}
+		armStrs = append(armStrs, "timeout "+n.Timeout.String()+":\n\t"+strings.Join(bodyStrs, "\n\t"))
+	}
+	return "select {\n" + strings.Join(armStrs, "\n") + "\n}"
+}
+
+type ThrowNode struct {
+	Value Node
+	Pos   Position
+}
+
+func (n *ThrowNode) String() string {
+	return "throw " + n.Value.String()
+}
+
+// TryNode runs Body and, when an error is thrown, binds it to CatchVar
+// (if any) and runs Catch.
+type TryNode struct {
+	Body     []Node
+	CatchVar string
+	Catch    []Node
+	Pos      Position
+}
+
+func (n *TryNode) String() string {
+	bodyStrs := []string{}
+	for _, stmt := range n.Body {
+		bodyStrs = append(bodyStrs, stmt.String())
+	}
+	catchStrs := []string{}
+	for _, stmt := range n.Catch {
+		catchStrs = append(catchStrs, stmt.String())
+	}
+	catch := "catch"
+	if n.CatchVar != "" {
+		catch += " " + n.CatchVar
+	}
+	return "try {\n\t" + strings.Join(bodyStrs, "\n\t") + "\n} " + catch + " {\n\t" + strings.Join(catchStrs, "\n\t") + "\n}"
+}
+
+// Types
+
+// TypeNode is a type expression: a plain name such as int, a function type
+// like func(int, string) -> bool, or a container such as list[int],
+// map[string, int] or stream[int].
+type TypeNode struct {
+	Name       string
+	Params     []*TypeNode
+	ReturnType *TypeNode
+	Pos        Position
+}
+
+func (n *TypeNode) String() string {
+	paramStrs := []string{}
+	for _, param := range n.Params {
+		paramStrs = append(paramStrs, param.String())
+	}
+	if n.Name == "func" {
+		return "func(" + strings.Join(paramStrs, ", ") + ") -> " + n.ReturnType.String()
+	}
+	if len(n.Params) > 0 {
+		return n.Name + "[" + strings.Join(paramStrs, ", ") + "]"
+	}
+	return n.Name
 }
 
 // Function related
 type ParameterNode struct {
 	Name string
-	Type string
+	Type *TypeNode
 }
 
 func (n *ParameterNode) String() string {
-	return n.Name + ": " + n.Type
+	return n.Name + ": " + n.Type.String()
 }
 
+// FuncDeclarationNode declares a function. Functions with a Receiver are
+// methods of that type and see the receiving value as `this`.
 type FuncDeclarationNode struct {
 	Name       string
+	Receiver   *TypeNode
 	Parameters []*ParameterNode
-	ReturnType string
+	ReturnType *TypeNode
 	Body       []Node
+	Pos        Position
 }
 
 func (n *FuncDeclarationNode) String() string {
+	bodyStrs := []string{}
+	for _, stmt := range n.Body {
+		bodyStrs = append(bodyStrs, stmt.String())
+	}
+	return n.Signature() + " {\n\t" + strings.Join(bodyStrs, "\n\t") + "\n}"
+}
+
+func (n *FuncDeclarationNode) Signature() string {
 	paramStrs := []string{}
 	for _, param := range n.Parameters {
 		paramStrs = append(paramStrs, param.String())
 	}
-	bodyStrs := []string{}
-	for _, stmt := range n.Body {
-		bodyStrs = append(bodyStrs, stmt.String())
+	name := n.Name
+	if n.Receiver != nil {
+		name = n.Receiver.String() + "." + name
+	}
+	return "func " + name + "(" + strings.Join(paramStrs, ", ") + ") -> " + n.ReturnType.String()
+}
+
+// Classes
+type FieldNode struct {
+	Name    string
+	Type    *TypeNode
+	Default Node
+	Pos     Position
+}
+
+func (n *FieldNode) String() string {
+	if n.Default == nil {
+		return n.Name + ": " + n.Type.String()
 	}
-	return "func " + n.Name + "(" + strings.Join(paramStrs, ", ") + ") -> " + n.ReturnType + " {\n\t" + strings.Join(bodyStrs, "\n\t") + "\n}"
+	return n.Name + ": " + n.Type.String() + " = " + n.Default.String()
+}
+
+// ClassDeclarationNode declares a class. Methods written inside the class
+// body are parsed as methods with the class as their receiver.
+type ClassDeclarationNode struct {
+	Name    string
+	Fields  []*FieldNode
+	Methods []*FuncDeclarationNode
+	Pos     Position
+}
+
+func (n *ClassDeclarationNode) String() string {
+	memberStrs := []string{}
+	for _, field := range n.Fields {
+		memberStrs = append(memberStrs, field.String())
+	}
+	for _, method := range n.Methods {
+		memberStrs = append(memberStrs, method.String())
+	}
+	return "class " + n.Name + " {\n\t" + strings.Join(memberStrs, "\n\t") + "\n}"
 }
 
 type ProgramNode struct {
+	Classes   []*ClassDeclarationNode
 	Functions []*FuncDeclarationNode
 }
 
 func (n *ProgramNode) String() string {
-	funcStrs := []string{}
+	declStrs := []string{}
+	for _, class := range n.Classes {
+		declStrs = append(declStrs, class.String())
+	}
 	for _, fn := range n.Functions {
-		funcStrs = append(funcStrs, fn.String())
+		declStrs = append(declStrs, fn.String())
 	}
-	return strings.Join(funcStrs, "\n\n")
+	return strings.Join(declStrs, "\n\n")
 }
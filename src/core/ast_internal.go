@@ -13,11 +13,42 @@ func VisualizeNode(node Node) {
 func printNode(node Node, prefix string) {
 	switch n := node.(type) {
 	case *ProgramNode:
+		for _, class := range n.Classes {
+			printNode(class, prefix)
+		}
 		for _, fn := range n.Functions {
 			printNode(fn, prefix)
 		}
+	case *ClassDeclarationNode:
+		printTableRow("Class", n.Name)
+		for _, field := range n.Fields {
+			printNode(field, "  "+prefix)
+		}
+		for _, method := range n.Methods {
+			printNode(method, "  "+prefix)
+		}
+	case *FieldNode:
+		printTableRow("Field", n.String())
+	case *MapNode:
+		printTableRow("Map", n.String())
+	case *ListNode:
+		printTableRow("List", n.String())
+	case *IndexNode:
+		printTableRow("Index", n.String())
+	case *SliceNode:
+		printTableRow("Slice", n.String())
+	case *IndexAssignmentNode:
+		printTableRow("IndexAssign", n.Object.String()+"["+n.Index.String()+"]")
+	case *FieldAccessNode:
+		printTableRow("FieldAccess", n.String())
+	case *FieldAssignmentNode:
+		printTableRow("FieldAssign", n.Object.String()+"."+n.Field)
 	case *FuncDeclarationNode:
-		printTableRow("Function", n.Name)
+		if n.Receiver != nil {
+			printTableRow("Method", n.Receiver.String()+"."+n.Name)
+		} else {
+			printTableRow("Function", n.Name)
+		}
 		for _, param := range n.Parameters {
 			printNode(param, "  "+prefix)
 		}
@@ -27,24 +58,90 @@ func printNode(node Node, prefix string) {
 	case *ParameterNode:
 		printTableRow("Parameter", n.String())
 	case *ForLoopNode:
-		printTableRow("ForLoop", "for "+n.Variable+" in range(...)")
+		if n.Range != nil {
+			printTableRow("ForLoop", "for "+n.Variable+" in range(...)")
+		} else {
+			printTableRow("ForLoop", "for "+n.Variable+" in "+n.Iterable.String())
+		}
 		for _, stmt := range n.Body {
 			printNode(stmt, "  "+prefix)
 		}
 	case *AssignmentNode:
-		printTableRow("Assignment", n.VarName+": "+n.Type)
+		if n.Type != nil {
+			printTableRow("Assignment", n.VarName+": "+n.Type.String())
+		} else {
+			printTableRow("Assignment", n.VarName)
+		}
 	case *ReturnNode:
-		printTableRow("Return", n.Value.String())
+		printTableRow("Return", n.String())
+	case *SendNode:
+		printTableRow("Send", n.String())
+	case *ReceiveNode:
+		printTableRow("Receive", n.String())
+	case *SelectNode:
+		printTableRow("Select", "select {...}")
+		for _, c := range n.Cases {
+			printNode(c, "  "+prefix)
+		}
+		if n.HasDefault {
+			printTableRow("Default", "default")
+			for _, stmt := range n.Default {
+				printNode(stmt, "  "+prefix)
+			}
+		}
+		if n.Timeout != nil {
+			printTableRow("Timeout", n.Timeout.String())
+			for _, stmt := range n.TimeoutBody {
+				printNode(stmt, "  "+prefix)
+			}
+		}
+	case *SelectCaseNode:
+		printTableRow("Case", n.Comm.String())
+		for _, stmt := range n.Body {
+			printNode(stmt, "  "+prefix)
+		}
+	case *ThrowNode:
+		printTableRow("Throw", n.Value.String())
+	case *TryNode:
+		printTableRow("Try", "")
+		for _, stmt := range n.Body {
+			printNode(stmt, "  "+prefix)
+		}
+		printTableRow("Catch", n.CatchVar)
+		for _, stmt := range n.Catch {
+			printNode(stmt, "  "+prefix)
+		}
+	case *SpawnNode:
+		printTableRow("Spawn", n.Call.FunctionName+"(...)")
 	case *BinOpNode:
 		printTableRow("BinOp", n.Op)
+	case *UnaryOpNode:
+		printTableRow("UnaryOp", n.Op)
+	case *IfNode:
+		printTableRow("If", n.Condition.String())
+		for _, stmt := range n.Then {
+			printNode(stmt, "  "+prefix)
+		}
+		if n.Else != nil {
+			printTableRow("Else", "")
+			for _, stmt := range n.Else {
+				printNode(stmt, "  "+prefix)
+			}
+		}
+	case *MethodCallNode:
+		printTableRow("MethodCall", n.Receiver.String()+"."+n.Method+"(...)")
 	case *FunctionCallNode:
-		printTableRow("FunctionCall", n.FunctionName+"(...)")
+		printTableRow("FunctionCall", n.CalleeString()+"(...)")
 	case *IdentifierNode:
 		printTableRow("Identifier", n.Name)
 	case *IntNode:
 		printTableRow("Int", n.String())
 	case *StringNode:
 		printTableRow("String", n.String())
+	case *BoolNode:
+		printTableRow("Bool", n.String())
+	case *NilNode:
+		printTableRow("Nil", n.String())
 	default:
 		printTableRow("Unknown", "")
 	}
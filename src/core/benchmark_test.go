@@ -0,0 +1,30 @@
+package up
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// BenchmarkPrograms runs the typed and untyped program pairs of
+// benchmarks/ on the tree-walker, like benchmarks/run.sh does for the
+// driver.
+func BenchmarkPrograms(b *testing.B) {
+	files, err := filepath.Glob("../../benchmarks/*.up")
+	if err != nil || len(files) == 0 {
+		b.Fatalf("no benchmark programs found: %v", err)
+	}
+	for _, file := range files {
+		program := parseFile(b, file)
+		b.Run(strings.TrimSuffix(filepath.Base(file), ".up"), func(b *testing.B) {
+			capture(b, func() {
+				for i := 0; i < b.N; i++ {
+					if _, err := Run(program, NewEnvironment()); err != nil {
+						b.Errorf("Uncaught error: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
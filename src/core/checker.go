@@ -0,0 +1,747 @@
+package up
+
+import "fmt"
+
+// CheckError is a problem found by Check before the program runs.
+type CheckError struct {
+	Message string
+	Pos     Position
+}
+
+func (e *CheckError) Error() string {
+	return e.Message + " at " + e.Pos.String()
+}
+
+// checkVar is what the checker knows about a variable. Type is nil when
+// the type cannot be inferred statically.
+type checkVar struct {
+	Type *TypeNode
+	// Declared is set for variables with an explicit type; untyped
+	// variables may change their type, which makes it unknown.
+	Declared bool
+	assigned bool
+	// Func and Class are set for names bound to declarations, so calls
+	// through them can be checked against the declared signature.
+	Func  *FuncDeclarationNode
+	Class *ClassDeclarationNode
+}
+
+// checkScope mirrors the runtime scoping: every function body is one scope
+// enclosed by the scope it is declared in.
+type checkScope struct {
+	vars  map[string]*checkVar
+	outer *checkScope
+}
+
+func newCheckScope(outer *checkScope) *checkScope {
+	return &checkScope{vars: make(map[string]*checkVar), outer: outer}
+}
+
+// local finds the variable an untyped assignment updates: the nearest
+// binding outside the global scope.
+func (s *checkScope) local(name string) (*checkVar, bool) {
+	for scope := s; scope.outer != nil; scope = scope.outer {
+		if v, ok := scope.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (s *checkScope) lookup(name string) (*checkVar, bool) {
+	for scope := s; scope != nil; scope = scope.outer {
+		if v, ok := scope.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+type checker struct {
+	builtins *Environment
+	methods  map[string]map[string]*FuncDeclarationNode
+	// literals holds the element types of the list and map literals,
+	// by the type inferred for each literal.
+	literals map[*TypeNode]*literal
+	errors   []error
+}
+
+// literal is the inferred types of the keys and values of a list or map
+// literal. They are only known while the literal is used directly.
+type literal struct {
+	keys, values []*TypeNode
+}
+
+// Check walks a parsed program without running it. It infers the types of
+// untyped locals and validates calls, arguments, returns and assignments
+// against the declared types, and returns every problem it finds.
+func Check(program *ProgramNode) []error {
+	c := &checker{builtins: NewEnvironment(), methods: make(map[string]map[string]*FuncDeclarationNode), literals: make(map[*TypeNode]*literal)}
+	global := newCheckScope(nil)
+
+	for _, class := range program.Classes {
+		global.vars[class.Name] = &checkVar{Type: &TypeNode{Name: "type"}, Class: class}
+		for _, method := range class.Methods {
+			c.defineMethod(method)
+		}
+	}
+	for _, function := range program.Functions {
+		if function.Receiver != nil {
+			c.defineMethod(function)
+			continue
+		}
+		global.vars[function.Name] = &checkVar{Type: funcType(function), Func: function}
+	}
+
+	for _, class := range program.Classes {
+		for _, field := range class.Fields {
+			c.checkTypeNode(field.Type, global)
+			if field.Default != nil {
+				c.expectType(field.Type, c.infer(field.Default, global), field.Pos, "field "+class.Name+"."+field.Name)
+			}
+		}
+		for _, method := range class.Methods {
+			c.checkFunction(method, global)
+		}
+	}
+	for _, function := range program.Functions {
+		c.checkFunction(function, global)
+	}
+	return c.errors
+}
+
+func (c *checker) errorf(pos Position, format string, args ...interface{}) {
+	c.errors = append(c.errors, &CheckError{Message: fmt.Sprintf(format, args...), Pos: pos})
+}
+
+func (c *checker) defineMethod(method *FuncDeclarationNode) {
+	table, ok := c.methods[method.Receiver.Name]
+	if !ok {
+		table = make(map[string]*FuncDeclarationNode)
+		c.methods[method.Receiver.Name] = table
+	}
+	table[method.Name] = method
+}
+
+func funcType(decl *FuncDeclarationNode) *TypeNode {
+	params := make([]*TypeNode, len(decl.Parameters))
+	for i, param := range decl.Parameters {
+		params[i] = param.Type
+	}
+	return &TypeNode{Name: "func", Params: params, ReturnType: decl.ReturnType, Pos: decl.Pos}
+}
+
+// checkTypeNode reports type names that are neither builtin nor classes.
+func (c *checker) checkTypeNode(t *TypeNode, scope *checkScope) bool {
+	known := true
+	switch t.Name {
+	case "any", "nil", "func", "list", "map", "stream":
+	default:
+		if v, ok := scope.lookup(t.Name); ok && v.Class != nil {
+			break
+		}
+		if declared, ok := c.builtins.Get(t.Name); ok {
+			if _, isType := declared.(*DataType); isType {
+				break
+			}
+		}
+		c.errorf(t.Pos, "Unknown type: %s", t.Name)
+		known = false
+	}
+	for _, param := range t.Params {
+		known = c.checkTypeNode(param, scope) && known
+	}
+	if t.ReturnType != nil {
+		known = c.checkTypeNode(t.ReturnType, scope) && known
+	}
+	return known
+}
+
+func (c *checker) checkFunction(decl *FuncDeclarationNode, outer *checkScope) {
+	scope := newCheckScope(outer)
+	if decl.Receiver != nil {
+		scope.vars["this"] = &checkVar{Type: decl.Receiver, Declared: true}
+	}
+	for _, param := range decl.Parameters {
+		c.checkTypeNode(param.Type, outer)
+		scope.vars[param.Name] = &checkVar{Type: param.Type, Declared: true}
+	}
+	c.checkTypeNode(decl.ReturnType, outer)
+
+	// variables assigned anywhere in the body belong to this scope, so a
+	// use that runs before the assignment is not reported as undefined.
+	c.hoist(decl.Body, scope)
+	c.checkBlock(decl.Body, scope, decl)
+}
+
+func (c *checker) hoist(body []Node, scope *checkScope) {
+	// untyped assignments update an enclosing function's variable when
+	// there is one; loop and catch variables are always local.
+	assigned := func(name string) {
+		if _, ok := scope.local(name); !ok {
+			scope.vars[name] = &checkVar{}
+		}
+	}
+	declare := func(name string) {
+		if _, ok := scope.vars[name]; name != "" && !ok {
+			scope.vars[name] = &checkVar{}
+		}
+	}
+	for _, stmt := range body {
+		switch n := stmt.(type) {
+		case *AssignmentNode:
+			if n.Type != nil {
+				declare(n.VarName)
+			} else {
+				assigned(n.VarName)
+			}
+		case *FuncDeclarationNode:
+			if n.Receiver == nil {
+				declare(n.Name)
+			}
+		case *IfNode:
+			c.hoist(n.Then, scope)
+			c.hoist(n.Else, scope)
+		case *ForLoopNode:
+			declare(n.Variable)
+			declare(n.ValueVariable)
+			c.hoist(n.Body, scope)
+		case *TryNode:
+			c.hoist(n.Body, scope)
+			declare(n.CatchVar)
+			c.hoist(n.Catch, scope)
+		case *SelectNode:
+			for _, arm := range n.Cases {
+				if assignment, ok := arm.Comm.(*AssignmentNode); ok {
+					assigned(assignment.VarName)
+				}
+				c.hoist(arm.Body, scope)
+			}
+			c.hoist(n.Default, scope)
+			c.hoist(n.TimeoutBody, scope)
+		}
+	}
+}
+
+func (c *checker) checkBlock(body []Node, scope *checkScope, function *FuncDeclarationNode) {
+	for _, stmt := range body {
+		c.checkStatement(stmt, scope, function)
+	}
+}
+
+func (c *checker) checkStatement(stmt Node, scope *checkScope, function *FuncDeclarationNode) {
+	switch n := stmt.(type) {
+	case *ReturnNode:
+		if n.Value == nil {
+			if function.ReturnType.Name != "nil" {
+				c.errorf(n.Pos, "Missing return value in function %s -> %s", functionName(function), function.ReturnType)
+			}
+			return
+		}
+		c.expectType(function.ReturnType, c.infer(n.Value, scope), n.Pos, "return value of "+functionName(function))
+	case *IfNode:
+		c.infer(n.Condition, scope)
+		c.checkBlock(n.Then, scope, function)
+		c.checkBlock(n.Else, scope, function)
+	case *ForLoopNode:
+		if n.Range != nil {
+			c.expectType(&TypeNode{Name: "int"}, c.infer(n.Range, scope), n.Pos, "range")
+			c.define(n.Variable, &TypeNode{Name: "int"}, scope, n.Pos)
+		} else {
+			iterable := c.infer(n.Iterable, scope)
+			keyType, valueType := iterationTypes(iterable)
+			if n.ValueVariable != "" {
+				c.define(n.Variable, keyType, scope, n.Pos)
+				c.define(n.ValueVariable, valueType, scope, n.Pos)
+			} else if iterable != nil && iterable.Name == "map" {
+				c.define(n.Variable, keyType, scope, n.Pos)
+			} else {
+				// a single variable walks the values of lists and streams
+				c.define(n.Variable, valueType, scope, n.Pos)
+			}
+		}
+		c.checkBlock(n.Body, scope, function)
+	case *TryNode:
+		c.checkBlock(n.Body, scope, function)
+		if n.CatchVar != "" {
+			c.define(n.CatchVar, &TypeNode{Name: "error"}, scope, n.Pos)
+		}
+		c.checkBlock(n.Catch, scope, function)
+	case *SelectNode:
+		for _, arm := range n.Cases {
+			c.infer(arm.Comm, scope)
+			c.checkBlock(arm.Body, scope, function)
+		}
+		if n.Timeout != nil {
+			// seconds may be an int or a float
+			if t := c.infer(n.Timeout, scope); t == nil || t.Name != "float" {
+				c.expectType(&TypeNode{Name: "int"}, t, n.Pos, "select timeout")
+			}
+		}
+		c.checkBlock(n.Default, scope, function)
+		c.checkBlock(n.TimeoutBody, scope, function)
+	case *ThrowNode:
+		c.infer(n.Value, scope)
+	case *SpawnNode:
+		c.infer(n.Call, scope)
+	default:
+		c.infer(stmt, scope)
+	}
+}
+
+// iterationTypes returns the key and value types of `for k, v in x`.
+func iterationTypes(t *TypeNode) (*TypeNode, *TypeNode) {
+	if t == nil {
+		return nil, nil
+	}
+	switch t.Name {
+	case "list", "stream":
+		if len(t.Params) == 1 {
+			return &TypeNode{Name: "int"}, t.Params[0]
+		}
+		return &TypeNode{Name: "int"}, nil
+	case "map":
+		if len(t.Params) == 2 {
+			return t.Params[0], t.Params[1]
+		}
+	}
+	return nil, nil
+}
+
+// define binds a local the way loop and catch variables are bound. A name
+// declared with a type keeps it, and t is checked against it.
+func (c *checker) define(name string, t *TypeNode, scope *checkScope, pos Position) {
+	if _, ok := scope.vars[name]; !ok {
+		t = c.variableType(t)
+		scope.vars[name] = &checkVar{Type: t, assigned: true}
+		return
+	}
+	c.assign(name, t, scope, pos)
+}
+
+// assign records an untyped assignment of a value of type t.
+func (c *checker) assign(name string, t *TypeNode, scope *checkScope, pos Position) {
+	v, ok := scope.local(name)
+	if !ok {
+		v = &checkVar{}
+		scope.vars[name] = v
+	}
+	if v.Declared {
+		c.expectType(v.Type, t, pos, "variable "+name)
+		return
+	}
+	// the first assignment infers the type; a later one with another
+	// type makes it unknown.
+	t = c.variableType(t)
+	v.Func, v.Class = nil, nil
+	if !v.assigned {
+		v.assigned = true
+		v.Type = t
+	} else if v.Type == nil || t == nil || !sameType(v.Type, t) {
+		v.Type = nil
+	}
+}
+
+func sameType(a, b *TypeNode) bool {
+	return a.String() == b.String()
+}
+
+// expectType reports got when a value of that type can never be stored in
+// a slot declared as want. Unknown types are accepted and left to the
+// runtime check.
+func (c *checker) expectType(want, got *TypeNode, pos Position, what string) {
+	if !assignable(want, got) || !c.elementsAssignable(want, got) {
+		c.errorf(pos, "Cannot use %s as %s in %s", got, want, what)
+	}
+}
+
+// elementsAssignable checks the elements of a literal of type got against
+// the element types of want.
+func (c *checker) elementsAssignable(want, got *TypeNode) bool {
+	lit, ok := c.literals[got]
+	if !ok || want == nil {
+		return true
+	}
+	switch {
+	case want.Name == "list" && len(want.Params) == 1:
+		return c.allAssignable(want.Params[0], lit.values)
+	case want.Name == "map" && len(want.Params) == 2:
+		return c.allAssignable(want.Params[0], lit.keys) && c.allAssignable(want.Params[1], lit.values)
+	}
+	return true
+}
+
+func (c *checker) allAssignable(want *TypeNode, types []*TypeNode) bool {
+	for _, t := range types {
+		if !assignable(want, t) || !c.elementsAssignable(want, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// variableType is the type of a variable assigned a value of type t. The
+// elements of a list or map literal may change later, so such a variable
+// holds a plain list or map.
+func (c *checker) variableType(t *TypeNode) *TypeNode {
+	if _, ok := c.literals[t]; ok {
+		return &TypeNode{Name: t.Name}
+	}
+	return t
+}
+
+// commonType is the type shared by every element of a literal, or any.
+func commonType(types []*TypeNode) *TypeNode {
+	for _, t := range types {
+		if t == nil || !sameType(t, types[0]) {
+			return &TypeNode{Name: "any"}
+		}
+	}
+	return types[0]
+}
+
+func assignable(want, got *TypeNode) bool {
+	if want == nil || got == nil || want.Name == "any" || got.Name == "any" {
+		return true
+	}
+	if got.Name == "nil" {
+		return !valueTypes[canonicalTypeName(want.Name)]
+	}
+	if canonicalTypeName(want.Name) != canonicalTypeName(got.Name) {
+		return false
+	}
+	if want.Name == "func" {
+		return len(want.Params) == len(got.Params)
+	}
+	if len(want.Params) != len(got.Params) {
+		return true
+	}
+	for i := range want.Params {
+		if !assignable(want.Params[i], got.Params[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// binOpType is the type of left op right, or nil when it is not known.
+func binOpType(op string, left, right *TypeNode) *TypeNode {
+	switch op {
+	case "==", "!=", "<", ">", "<=", ">=", "&&", "||":
+		return &TypeNode{Name: "bool"}
+	}
+	if op == "+" && (left != nil && left.Name == "string" || right != nil && right.Name == "string") {
+		return &TypeNode{Name: "string"}
+	}
+	if left == nil || right == nil {
+		return nil
+	}
+	// a byte operand is an int
+	left, right = byteAsInt(left), byteAsInt(right)
+	if left.Name == right.Name && (left.Name == "int" || left.Name == "float" || left.Name == "string" && op == "+") {
+		return left
+	}
+	// an int operand is promoted to float
+	if isNumberType(left) && isNumberType(right) {
+		return &TypeNode{Name: "float"}
+	}
+	return nil
+}
+
+// elementType is the type of object[index], or nil when it is not known.
+func elementType(object *TypeNode) *TypeNode {
+	if object == nil {
+		return nil
+	}
+	switch {
+	case object.Name == "list" && len(object.Params) == 1:
+		return object.Params[0]
+	case object.Name == "map" && len(object.Params) == 2:
+		return object.Params[1]
+	case object.Name == "string":
+		return &TypeNode{Name: "byte"}
+	}
+	return nil
+}
+
+func byteAsInt(t *TypeNode) *TypeNode {
+	if t.Name == "byte" {
+		return &TypeNode{Name: "int"}
+	}
+	return t
+}
+
+func isNumberType(t *TypeNode) bool {
+	return t.Name == "int" || t.Name == "float"
+}
+
+func functionName(decl *FuncDeclarationNode) string {
+	if decl.Name == "" {
+		return "func literal"
+	}
+	if decl.Receiver != nil {
+		return decl.Receiver.Name + "." + decl.Name
+	}
+	return decl.Name
+}
+
+// infer checks an expression and returns its static type, or nil when it
+// is unknown.
+func (c *checker) infer(node Node, scope *checkScope) *TypeNode {
+	switch n := node.(type) {
+	case *IntNode:
+		return &TypeNode{Name: "int"}
+	case *FloatNode:
+		return &TypeNode{Name: "float"}
+	case *StringNode:
+		return &TypeNode{Name: "string"}
+	case *BoolNode:
+		return &TypeNode{Name: "bool"}
+	case *NilNode:
+		return &TypeNode{Name: "nil"}
+	case *IdentifierNode:
+		if v, ok := scope.lookup(n.Name); ok {
+			return v.Type
+		}
+		if value, ok := c.builtins.Get(n.Name); ok {
+			return &TypeNode{Name: TypeOf(value).Name}
+		}
+		c.errorf(n.Pos, "Unknown identifier: %s", n.Name)
+		return nil
+	case *ListNode:
+		// the elements are checked where the literal is stored; a variable
+		// holding it is a list of any.
+		lit := &literal{values: make([]*TypeNode, len(n.Elements))}
+		for i, element := range n.Elements {
+			lit.values[i] = c.infer(element, scope)
+		}
+		t := &TypeNode{Name: "list"}
+		if len(n.Elements) > 0 {
+			t.Params = []*TypeNode{commonType(lit.values)}
+		}
+		c.literals[t] = lit
+		return t
+	case *MapNode:
+		lit := &literal{keys: make([]*TypeNode, len(n.Keys)), values: make([]*TypeNode, len(n.Keys))}
+		for i := range n.Keys {
+			lit.keys[i] = c.infer(n.Keys[i], scope)
+			lit.values[i] = c.infer(n.Values[i], scope)
+		}
+		t := &TypeNode{Name: "map"}
+		if len(n.Keys) > 0 {
+			t.Params = []*TypeNode{commonType(lit.keys), commonType(lit.values)}
+		}
+		c.literals[t] = lit
+		return t
+	case *BinOpNode:
+		return binOpType(n.Op, c.infer(n.Left, scope), c.infer(n.Right, scope))
+	case *UnaryOpNode:
+		operand := c.infer(n.Operand, scope)
+		if n.Op == "!" {
+			return &TypeNode{Name: "bool"}
+		}
+		return operand
+	case *AssignmentNode:
+		value := c.infer(n.Value, scope)
+		if n.Type != nil {
+			if c.checkTypeNode(n.Type, scope) {
+				c.expectType(n.Type, value, n.Pos, "variable "+n.VarName)
+			}
+			scope.vars[n.VarName] = &checkVar{Type: n.Type, Declared: true}
+		} else {
+			c.assign(n.VarName, value, scope, n.Pos)
+		}
+		return value
+	case *FuncDeclarationNode:
+		if n.Receiver != nil {
+			c.defineMethod(n)
+		} else if n.Name != "" {
+			scope.vars[n.Name] = &checkVar{Type: funcType(n), Func: n}
+		}
+		c.checkFunction(n, scope)
+		return funcType(n)
+	case *FunctionCallNode:
+		return c.inferCall(n, scope)
+	case *MethodCallNode:
+		return c.inferMethodCall(n, scope)
+	case *FieldAccessNode:
+		return c.fieldType(c.infer(n.Object, scope), n.Field, scope, n.Pos)
+	case *FieldAssignmentNode:
+		object := c.infer(n.Object, scope)
+		field := c.fieldType(object, n.Field, scope, n.Pos)
+		value := c.infer(n.Value, scope)
+		if n.Op != "" {
+			value = binOpType(n.Op, field, value)
+		}
+		if field != nil {
+			c.expectType(field, value, n.Pos, "field "+object.Name+"."+n.Field)
+		}
+		return value
+	case *IndexNode:
+		object := c.infer(n.Object, scope)
+		c.infer(n.Index, scope)
+		return elementType(object)
+	case *SliceNode:
+		object := c.infer(n.Object, scope)
+		if n.Low != nil {
+			c.infer(n.Low, scope)
+		}
+		if n.High != nil {
+			c.infer(n.High, scope)
+		}
+		return object
+	case *IndexAssignmentNode:
+		object := c.infer(n.Object, scope)
+		c.infer(n.Index, scope)
+		value := c.infer(n.Value, scope)
+		if n.Op != "" {
+			value = binOpType(n.Op, elementType(object), value)
+		}
+		if object != nil && object.Name == "list" && len(object.Params) == 1 {
+			c.expectType(object.Params[0], value, n.Pos, "element of "+object.String())
+		} else if object != nil && object.Name == "map" && len(object.Params) == 2 {
+			c.expectType(object.Params[1], value, n.Pos, "value of "+object.String())
+		}
+		return value
+	case *SendNode:
+		stream := c.infer(n.Stream, scope)
+		value := c.infer(n.Value, scope)
+		if stream != nil && stream.Name == "stream" && len(stream.Params) == 1 {
+			c.expectType(stream.Params[0], value, n.Pos, "send to "+stream.String())
+		}
+		return nil
+	case *ReceiveNode:
+		stream := c.infer(n.Stream, scope)
+		if stream != nil && stream.Name == "stream" && len(stream.Params) == 1 {
+			return stream.Params[0]
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// classField looks up a field of a value of class type object. class is
+// nil when object is not a class type.
+func classField(object *TypeNode, field string, scope *checkScope) (class *ClassDeclarationNode, declared *FieldNode) {
+	if object == nil {
+		return nil, nil
+	}
+	v, ok := scope.lookup(object.Name)
+	if !ok || v.Class == nil {
+		return nil, nil
+	}
+	for _, f := range v.Class.Fields {
+		if f.Name == field {
+			return v.Class, f
+		}
+	}
+	return v.Class, nil
+}
+
+func (c *checker) fieldType(object *TypeNode, field string, scope *checkScope, pos Position) *TypeNode {
+	class, declared := classField(object, field, scope)
+	if declared != nil {
+		return declared.Type
+	}
+	if class != nil {
+		c.errorf(pos, "%s has no field %s", class.Name, field)
+	}
+	return nil
+}
+
+func (c *checker) inferArguments(args []Node, scope *checkScope) []*TypeNode {
+	types := make([]*TypeNode, len(args))
+	for i, arg := range args {
+		types[i] = c.infer(arg, scope)
+	}
+	return types
+}
+
+// checkArguments validates a call against the parameters of decl.
+func (c *checker) checkArguments(decl *FuncDeclarationNode, args []*TypeNode, pos Position) {
+	name := functionName(decl)
+	if len(args) != len(decl.Parameters) {
+		c.errorf(pos, "%s expects %d arguments but got %d", name, len(decl.Parameters), len(args))
+		return
+	}
+	for i, param := range decl.Parameters {
+		c.expectType(param.Type, args[i], pos, "argument "+param.Name+" of "+name)
+	}
+}
+
+func (c *checker) inferCall(n *FunctionCallNode, scope *checkScope) *TypeNode {
+	var callee *TypeNode
+	var v *checkVar
+	if n.Callee != nil {
+		callee = c.infer(n.Callee, scope)
+	} else if found, ok := scope.lookup(n.FunctionName); ok {
+		v, callee = found, found.Type
+	} else if value, ok := c.builtins.Get(n.FunctionName); ok {
+		c.inferArguments(n.Arguments, scope)
+		if t, isType := value.(*DataType); isType {
+			return &TypeNode{Name: t.Name}
+		}
+		return nil
+	} else {
+		c.inferArguments(n.Arguments, scope)
+		c.errorf(n.Pos, "Unknown function: %s", n.FunctionName)
+		return nil
+	}
+
+	args := c.inferArguments(n.Arguments, scope)
+	switch {
+	case v != nil && v.Func != nil:
+		c.checkArguments(v.Func, args, n.Pos)
+		return v.Func.ReturnType
+	case v != nil && v.Class != nil:
+		return c.checkConstruct(v.Class, args, n.Pos)
+	case callee != nil && callee.Name == "func" && callee.ReturnType != nil:
+		if len(args) != len(callee.Params) {
+			c.errorf(n.Pos, "%s expects %d arguments but got %d", n.CalleeString(), len(callee.Params), len(args))
+			return callee.ReturnType
+		}
+		for i, param := range callee.Params {
+			c.expectType(param, args[i], n.Pos, fmt.Sprintf("argument %d of %s", i+1, n.CalleeString()))
+		}
+		return callee.ReturnType
+	case callee != nil && callee.Name != "func" && callee.Name != "type" && callee.Name != "any":
+		c.errorf(n.Pos, "Cannot call %s value %s", callee, n.CalleeString())
+	}
+	return nil
+}
+
+func (c *checker) checkConstruct(class *ClassDeclarationNode, args []*TypeNode, pos Position) *TypeNode {
+	result := &TypeNode{Name: class.Name}
+	if init, ok := c.methods[class.Name]["init"]; ok {
+		c.checkArguments(init, args, pos)
+		return result
+	}
+	if len(args) > len(class.Fields) {
+		c.errorf(pos, "%s expects at most %d arguments but got %d", class.Name, len(class.Fields), len(args))
+		return result
+	}
+	for i, arg := range args {
+		field := class.Fields[i]
+		c.expectType(field.Type, arg, pos, "field "+class.Name+"."+field.Name)
+	}
+	return result
+}
+
+func (c *checker) inferMethodCall(n *MethodCallNode, scope *checkScope) *TypeNode {
+	receiver := c.infer(n.Receiver, scope)
+	args := c.inferArguments(n.Arguments, scope)
+	if receiver == nil {
+		return nil
+	}
+	if method, ok := c.methods[receiver.Name][n.Method]; ok {
+		c.checkArguments(method, args, n.Pos)
+		return method.ReturnType
+	}
+	// a field holding a function, or a builtin called with the receiver
+	// as its first argument
+	if _, field := classField(receiver, n.Method, scope); field != nil && field.Type.Name == "func" {
+		return field.Type.ReturnType
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package up
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func checkErrors(t *testing.T, program *ProgramNode) []string {
+	var messages []string
+	for _, err := range Check(program) {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errors []string
+	}{
+		{
+			name: "argument count",
+			source: `func add(a: int, b: int) -> int {
+    return a + b
+}
+func main() -> nil {
+    print(add(1))
+}`,
+			errors: []string{"add expects 2 arguments but got 1 at [5:11]"},
+		},
+		{
+			name: "argument type",
+			source: `func add(a: int, b: int) -> int {
+    return a + b
+}
+func main() -> nil {
+    print(add(1, "2"))
+}`,
+			errors: []string{"Cannot use string as int in argument b of add at [5:11]"},
+		},
+		{
+			name: "assignment",
+			source: `func main() -> nil {
+    total: int = 0
+    total = "twelve"
+    name: string = 1
+}`,
+			errors: []string{
+				"Cannot use string as int in variable total at [3:5]",
+				"Cannot use int as string in variable name at [4:5]",
+			},
+		},
+		{
+			name: "loop variable keeps its declared type",
+			source: `func main() -> nil {
+    word: string = ""
+    for word in range(3) {
+        print(word)
+    }
+}`,
+			errors: []string{"Cannot use int as string in variable word at [3:5]"},
+		},
+		{
+			name: "list literal elements",
+			source: `func main() -> nil {
+    ok: list[int] = [1, 2]
+    words: list[int] = ["a"]
+    mixed: list[int] = [1, "a"]
+    counts: map[string, int] = {"a": "b"}
+}`,
+			errors: []string{
+				"Cannot use list[string] as list[int] in variable words at [3:5]",
+				"Cannot use list[any] as list[int] in variable mixed at [4:5]",
+				"Cannot use map[string, string] as map[string, int] in variable counts at [5:5]",
+			},
+		},
+		{
+			name: "untyped list variable",
+			source: `func total(xs: list[int]) -> int {
+    return len(xs)
+}
+func main() -> nil {
+    xs = ["a"]
+    xs[0] = 1
+    print(total(xs))
+}`,
+		},
+		{
+			name: "unknown names",
+			source: `func main() -> nil {
+    print(missing)
+    nothing()
+    x: celsius = 1
+}`,
+			errors: []string{
+				"Unknown identifier: missing at [2:11]",
+				"Unknown function: nothing at [3:5]",
+				"Unknown type: celsius at [4:8]",
+			},
+		},
+		{
+			name: "return",
+			source: `func name() -> string {
+    return 1
+}
+func count() -> int {
+    return
+}
+func main() -> nil {
+    print(name(), count())
+}`,
+			errors: []string{
+				"Cannot use int as string in return value of name at [2:5]",
+				"Missing return value in function count -> int at [5:5]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkErrors(t, parseSource(t, tt.source)); !reflect.DeepEqual(got, tt.errors) {
+				t.Errorf("got errors %q, want %q", got, tt.errors)
+			}
+		})
+	}
+}
+
+// TestCheckExamples checks every example. The ones listed fail on purpose;
+// all others must pass cleanly.
+func TestCheckExamples(t *testing.T) {
+	failing := map[string][]string{
+		"multi_funcs.up":                {"x expects 1 arguments but got 0 at [10:11]"},
+		"reserved_duplication_check.up": {"integer_mul expects 1 arguments but got 0 at [23:27]"},
+		"typed_variable.up":             {"Cannot use string as int in variable total at [11:9]"},
+	}
+	files, err := filepath.Glob("../../examples/*.up")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(name, func(t *testing.T) {
+			if got := checkErrors(t, parseFile(t, file)); !reflect.DeepEqual(got, failing[name]) {
+				t.Errorf("got errors %q, want %q", got, failing[name])
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package up
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Class is the runtime side of a class declaration. Every class is a
+// DataType with Class set, so it is called like any other type to create
+// an instance: Point(1, 2).
+type Class struct {
+	Decl *ClassDeclarationNode
+	Env  *Environment
+}
+
+// Object is an instance of a class. Objects can be shared between threads,
+// so their fields are guarded.
+type Object struct {
+	class  *DataType
+	mu     sync.RWMutex
+	fields map[string]Value
+}
+
+func NewClassType(decl *ClassDeclarationNode, env *Environment) *DataType {
+	return &DataType{Name: decl.Name, Class: &Class{Decl: decl, Env: env}}
+}
+
+// construct creates an object with every field set to its default. When
+// the class has an init method it receives the arguments, otherwise the
+// arguments initialize the leading fields in declaration order. pos is the
+// position of the call, where errors calling init are reported.
+func (c *Class) construct(thread *Thread, t *DataType, args []Value, pos Position) *Object {
+	// defaults are evaluated in the class scope, on the constructing thread.
+	env := NewEnclosedEnvironment(c.Env)
+	env.thread = thread
+
+	obj := NewObject(t)
+	for _, field := range c.Decl.Fields {
+		if field.Default != nil {
+			obj.fields[field.Name] = ExecuteNode(field.Default, env)
+		}
+	}
+
+	if init, ok := c.Env.Methods().Lookup(t.Name, "init"); ok {
+		callFunction(thread, init.Bind(obj), args, pos)
+		return obj
+	}
+	c.AssignFields(obj, args)
+	return obj
+}
+
+// NewObject creates an instance of the class t with every field set to the
+// zero value of its type.
+func NewObject(t *DataType) *Object {
+	obj := &Object{class: t, fields: make(map[string]Value, len(t.Class.Decl.Fields))}
+	for _, field := range t.Class.Decl.Fields {
+		obj.fields[field.Name] = zeroValue(field.Type)
+	}
+	return obj
+}
+
+// AssignFields initializes the leading fields of obj from the arguments of
+// a class without an init method.
+func (c *Class) AssignFields(obj *Object, args []Value) {
+	t := obj.class
+	if len(args) > len(c.Decl.Fields) {
+		panic(fmt.Sprintf("%s expects at most %d arguments but got %d", t.Name, len(c.Decl.Fields), len(args)))
+	}
+	for i, arg := range args {
+		field := c.Decl.Fields[i]
+		if !Conforms(field.Type, arg, c.Env) {
+			panic(fmt.Sprintf("Cannot use %s as %s in field %s.%s", TypeOf(arg).Name, field.Type, t.Name, field.Name))
+		}
+		obj.fields[field.Name] = arg
+	}
+}
+
+// Field returns the declaration of the named field, or nil.
+func (c *Class) Field(name string) *FieldNode {
+	for _, field := range c.Decl.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+func (o *Object) Get(field string) (Value, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	value, ok := o.fields[field]
+	return value, ok
+}
+
+// Set updates a declared field. Objects cannot grow new fields.
+func (o *Object) Set(field string, value Value) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.fields[field]; !ok {
+		return false
+	}
+	o.fields[field] = value
+	return true
+}
+
+// Type returns the class of the object.
+func (o *Object) Type() *DataType {
+	return o.class
+}
+
+func (o *Object) String() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	fieldStrs := []string{}
+	for _, field := range o.class.Class.Decl.Fields {
+		fieldStrs = append(fieldStrs, field.Name+": "+stringify(o.fields[field.Name]))
+	}
+	return o.class.Name + "{" + strings.Join(fieldStrs, ", ") + "}"
+}
+
+func zeroValue(t *TypeNode) Value {
+	switch t.Name {
+	case "int":
+		return Int(0)
+	case "float":
+		return Float(0)
+	case "string", "str":
+		return String("")
+	case "bool":
+		return Bool(false)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+package up
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Conversions between the scalar types. They back the types int, float,
+// bool and string (or its alias str) when these are called as functions,
+// e.g. int("42"). A string that cannot be parsed raises an error that
+// scripts can catch.
+
+func conversionArgument(t *DataType, args []Value) Value {
+	if len(args) != 1 {
+		panic(fmt.Sprintf("%s expects 1 argument but got %d", t.Name, len(args)))
+	}
+	return args[0]
+}
+
+func convertToInt(value Value) Int {
+	switch v := value.(type) {
+	case Int:
+		return v
+	case Byte:
+		return Int(v)
+	case Bool:
+		if v {
+			return 1
+		}
+		return 0
+	case Float:
+		// truncated toward zero; the bounds are exclusive because the max
+		// int is not exactly representable as a float
+		if math.IsNaN(float64(v)) || v >= -Float(minInt) || v < Float(minInt) {
+			panic(fmt.Sprintf("Cannot convert %s to int", v))
+		}
+		return Int(v)
+	case String:
+		i, err := strconv.Atoi(string(v))
+		if err != nil {
+			panic(fmt.Sprintf("Cannot convert %q to int", string(v)))
+		}
+		return Int(i)
+	default:
+		panic(fmt.Sprintf("Cannot convert %s to int", TypeOf(value).Name))
+	}
+}
+
+func convertToFloat(value Value) Float {
+	switch v := value.(type) {
+	case Float:
+		return v
+	case Int:
+		return Float(v)
+	case Byte:
+		return Float(v)
+	case Bool:
+		if v {
+			return 1
+		}
+		return 0
+	case String:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			panic(fmt.Sprintf("Cannot convert %q to float", string(v)))
+		}
+		return Float(f)
+	default:
+		panic(fmt.Sprintf("Cannot convert %s to float", TypeOf(value).Name))
+	}
+}
+
+// convertToBool parses strings and otherwise follows the truthiness of
+// conditions.
+func convertToBool(value Value) Bool {
+	if s, ok := value.(String); ok {
+		b, err := strconv.ParseBool(string(s))
+		if err != nil {
+			panic(fmt.Sprintf("Cannot convert %q to bool", string(s)))
+		}
+		return Bool(b)
+	}
+	return Bool(Truthy(value))
+}
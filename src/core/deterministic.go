@@ -0,0 +1,308 @@
+package up
+
+import (
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// In deterministic mode a single processor runs every thread, and what
+// would otherwise depend on timing is decided by a seeded random source:
+// which runnable thread runs next, and at which preemption point the
+// running thread yields. Streams do not block on Go channels: waiting
+// threads queue on the stream and are woken by the thread completing
+// their operation, and sleep and select timeouts use a virtual clock that
+// jumps forward when no thread can run. A seed therefore always produces
+// the same interleaving.
+
+// maxPreemptionBudget bounds how many preemption points a thread passes
+// before it yields in deterministic mode.
+const maxPreemptionBudget = 64
+
+// SetDeterministic switches to deterministic scheduling driven by seed.
+// It must be called before the first thread runs.
+func (s *Scheduler) SetDeterministic(seed int64) {
+	s.SetProcessors(1)
+	s.seed = seed
+	s.rng = rand.New(rand.NewSource(seed))
+}
+
+// Seed returns the seed of deterministic mode, and whether it is on.
+func (s *Scheduler) Seed() (int64, bool) {
+	return s.seed, s.rng != nil
+}
+
+func (t *Thread) deterministic() bool {
+	return t != nil && t.sched.rng != nil
+}
+
+func (s *Scheduler) intn(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Intn(n)
+}
+
+// newBudget draws the number of preemption points until the next yield.
+func (s *Scheduler) newBudget() int {
+	return 1 + s.intn(maxPreemptionBudget)
+}
+
+// popRandom takes a random thread from the global queue, which holds every
+// runnable thread in deterministic mode.
+func (s *Scheduler) popRandom() *Thread {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.global)
+	if n == 0 {
+		return nil
+	}
+	i := s.rng.Intn(n)
+	t := s.global[i]
+	s.global = append(s.global[:i], s.global[i+1:]...)
+	atomic.AddInt64(&s.runnable, -1)
+	return t
+}
+
+// wait parks t for reason until another thread or a timer makes it
+// runnable again.
+func (t *Thread) wait(reason waitReason) {
+	t.park(reason)
+	<-t.wake
+	t.checkStop()
+}
+
+// ready makes a waiting thread runnable.
+func (s *Scheduler) ready(t *Thread) {
+	s.mu.Lock()
+	t.waiting = notWaiting
+	s.wakeups++
+	s.mu.Unlock()
+	atomic.AddInt64(&s.parked, -1)
+	s.makeRunnable(t)
+}
+
+// timer wakes a sleeping thread, or times out a select, at a point of the
+// virtual clock.
+type timer struct {
+	at     time.Duration
+	seq    int64
+	thread *Thread
+	waiter *waiter
+}
+
+func (s *Scheduler) addTimer(d time.Duration, t *Thread, w *waiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timerSeq++
+	tm := timer{at: s.now + d, seq: s.timerSeq, thread: t, waiter: w}
+	i := sort.Search(len(s.timers), func(i int) bool {
+		return s.timers[i].at > tm.at
+	})
+	s.timers = append(s.timers, timer{})
+	copy(s.timers[i+1:], s.timers[i:])
+	s.timers[i] = tm
+}
+
+// fireTimers advances the virtual clock to the earliest timer and fires
+// every timer due then. It reports whether there was one.
+func (s *Scheduler) fireTimers() bool {
+	s.mu.Lock()
+	if len(s.timers) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	s.now = s.timers[0].at
+	n := 1
+	for n < len(s.timers) && s.timers[n].at == s.now {
+		n++
+	}
+	due := append([]timer(nil), s.timers[:n]...)
+	s.timers = s.timers[n:]
+	s.mu.Unlock()
+
+	for _, tm := range due {
+		if tm.waiter == nil {
+			s.ready(tm.thread)
+		} else if !tm.waiter.done {
+			tm.waiter.complete(SelectTimeout, nil, false)
+		}
+	}
+	return true
+}
+
+// Sleep pauses the thread for d without holding a processor.
+func (t *Thread) Sleep(d time.Duration) {
+	if t.deterministic() {
+		t.sched.addTimer(d, t, nil)
+		t.wait(waitSleep)
+		return
+	}
+	t.block(waitSleep, func() { time.Sleep(d) })
+}
+
+// waiter is a thread waiting on streams in deterministic mode. A select
+// waits on several streams at once; the first one ready completes it.
+type waiter struct {
+	thread *Thread
+	done   bool
+	chosen int
+	value  Value
+	ok     bool
+	// closed is set when a send could not complete because the stream
+	// was closed.
+	closed bool
+}
+
+func (w *waiter) complete(chosen int, value Value, ok bool) {
+	w.done, w.chosen, w.value, w.ok = true, chosen, value, ok
+	w.thread.sched.ready(w.thread)
+}
+
+// streamWaiter is a waiter queued on a stream: index is its case in a
+// select, and value what it sends.
+type streamWaiter struct {
+	waiter *waiter
+	index  int
+	value  Value
+}
+
+// firstWaiter removes the first waiter of q that still waits.
+func firstWaiter(q *[]streamWaiter) (streamWaiter, bool) {
+	for len(*q) > 0 {
+		sw := (*q)[0]
+		*q = (*q)[1:]
+		if !sw.waiter.done {
+			return sw, true
+		}
+	}
+	return streamWaiter{}, false
+}
+
+// hasWaiter reports whether a waiter of q still waits, dropping the ones
+// completed through another stream.
+func hasWaiter(q *[]streamWaiter) bool {
+	for len(*q) > 0 && (*q)[0].waiter.done {
+		*q = (*q)[1:]
+	}
+	return len(*q) > 0
+}
+
+func (s *Stream) canSend() bool {
+	return s.closed || hasWaiter(&s.recvq) || len(s.ch) < cap(s.ch)
+}
+
+func (s *Stream) canReceive() bool {
+	return len(s.ch) > 0 || hasWaiter(&s.sendq) || s.closed
+}
+
+// sendNow performs a send canSend allowed.
+func (s *Stream) sendNow(value Value) {
+	if s.closed {
+		panic("Send on closed stream")
+	}
+	if sw, ok := firstWaiter(&s.recvq); ok {
+		sw.waiter.complete(sw.index, value, true)
+		return
+	}
+	s.ch <- value
+}
+
+// receiveNow performs a receive canReceive allowed. A sender waiting on a
+// full stream moves its value into the freed space.
+func (s *Stream) receiveNow() (Value, bool) {
+	if len(s.ch) > 0 {
+		value := <-s.ch
+		if sw, ok := firstWaiter(&s.sendq); ok {
+			s.ch <- sw.value
+			sw.waiter.complete(sw.index, nil, true)
+		}
+		return value, true
+	}
+	if sw, ok := firstWaiter(&s.sendq); ok {
+		sw.waiter.complete(sw.index, nil, true)
+		return sw.value, true
+	}
+	return nil, false
+}
+
+// wakeWaiters completes the waiters of a stream that was closed.
+func (s *Stream) wakeWaiters() {
+	for _, sw := range s.recvq {
+		if !sw.waiter.done {
+			sw.waiter.complete(sw.index, nil, false)
+		}
+	}
+	for _, sw := range s.sendq {
+		if !sw.waiter.done {
+			sw.waiter.closed = true
+			sw.waiter.complete(sw.index, nil, false)
+		}
+	}
+	s.recvq, s.sendq = nil, nil
+}
+
+func (s *Scheduler) send(t *Thread, stream *Stream, value Value, reason waitReason) {
+	if stream.canSend() {
+		stream.sendNow(value)
+		return
+	}
+	w := &waiter{thread: t}
+	stream.sendq = append(stream.sendq, streamWaiter{waiter: w, value: value})
+	t.wait(reason)
+	if w.closed {
+		panic("Send on closed stream")
+	}
+}
+
+func (s *Scheduler) receive(t *Thread, stream *Stream) (Value, bool) {
+	if stream.canReceive() {
+		return stream.receiveNow()
+	}
+	w := &waiter{thread: t}
+	stream.recvq = append(stream.recvq, streamWaiter{waiter: w})
+	t.wait(waitReceive)
+	return w.value, w.ok
+}
+
+// selectCases is Select in deterministic mode. Among several ready cases
+// the random source picks one, like Go picks one at random.
+func (s *Scheduler) selectCases(t *Thread, cases []SelectCase, timeout time.Duration, hasTimeout bool, hasDefault bool) (int, Value) {
+	var ready []int
+	for i, c := range cases {
+		if c.Send && c.Stream.canSend() || !c.Send && c.Stream.canReceive() {
+			ready = append(ready, i)
+		}
+	}
+	if len(ready) > 0 {
+		i := ready[s.intn(len(ready))]
+		if cases[i].Send {
+			cases[i].Stream.sendNow(cases[i].Value)
+			return i, nil
+		}
+		value, _ := cases[i].Stream.receiveNow()
+		return i, value
+	}
+	if hasDefault {
+		return SelectDefault, nil
+	}
+
+	w := &waiter{thread: t}
+	for i, c := range cases {
+		if c.Send {
+			c.Stream.sendq = append(c.Stream.sendq, streamWaiter{waiter: w, index: i, value: c.Value})
+		} else {
+			c.Stream.recvq = append(c.Stream.recvq, streamWaiter{waiter: w, index: i})
+		}
+	}
+	reason := waitSelect
+	if hasTimeout {
+		s.addTimer(timeout, t, w)
+		reason = waitTimeout
+	}
+	t.wait(reason)
+	if w.closed {
+		panic("Send on closed stream")
+	}
+	return w.chosen, w.value
+}
@@ -0,0 +1,269 @@
+package up
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Frame is a function call on the stack of a thread. Pos is where the call
+// is at: the call of the frame above it, or, for the innermost frame, the
+// last blocking operation or preemption point it reached, if any.
+type Frame struct {
+	Function string
+	Pos      Position
+}
+
+// MaxCallDepth bounds the calls on the stack of a thread, so unbounded
+// recursion raises an up error instead of overflowing the Go stack.
+const MaxCallDepth = 10000
+
+// Enter pushes a call of function made at pos, and returns the depth to
+// Unwind to once it returns.
+func (t *Thread) Enter(function string, pos Position) int {
+	if t == nil {
+		return 0
+	}
+	depth := len(t.frames)
+	if depth >= MaxCallDepth {
+		panic(NewRuntimeError(pos, "Maximum call depth of %d exceeded calling %s", MaxCallDepth, function))
+	}
+	if depth > 0 {
+		t.frames[depth-1].Pos = pos
+	}
+	t.frames = append(t.frames, Frame{Function: function})
+	return depth
+}
+
+// Unwind pops the calls above depth, after a return or a caught error.
+func (t *Thread) Unwind(depth int) {
+	if t == nil {
+		return
+	}
+	t.frames = t.frames[:depth]
+}
+
+// Depth is the number of calls on the stack.
+func (t *Thread) Depth() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.frames)
+}
+
+// At records that the innermost call reached pos, before an operation
+// that may block.
+func (t *Thread) At(pos Position) {
+	if t == nil || len(t.frames) == 0 {
+		return
+	}
+	t.frames[len(t.frames)-1].Pos = pos
+}
+
+// waitReason is what a parked thread waits for.
+type waitReason int
+
+const (
+	notWaiting waitReason = iota
+	waitSend
+	waitReceive
+	waitSelect
+	waitTimeout
+	waitSleep
+	waitLock
+	waitThreads
+)
+
+var waitReasonNames = [...]string{
+	notWaiting:  "",
+	waitSend:    "stream send",
+	waitReceive: "stream receive",
+	waitSelect:  "select",
+	waitTimeout: "select with timeout",
+	waitSleep:   "sleep",
+	waitLock:    "mutex lock",
+	waitThreads: "wait for threads",
+}
+
+func (r waitReason) String() string {
+	return waitReasonNames[r]
+}
+
+// deadlockGrace is how long the threads must stay blocked before a
+// deadlock is reported. A thread whose stream operation just completed
+// may still count as waiting until it takes a processor again.
+const deadlockGrace = 50 * time.Millisecond
+
+// startDetector checks for a deadlock once every processor became idle.
+// s.mu must be held.
+func (s *Scheduler) startDetector() {
+	if s.detecting {
+		return
+	}
+	s.detecting = true
+	go s.detect()
+}
+
+func (s *Scheduler) detect() {
+	for {
+		s.mu.Lock()
+		if !s.deadlocked() {
+			s.detecting = false
+			s.mu.Unlock()
+			return
+		}
+		wakeups := s.wakeups
+		s.mu.Unlock()
+
+		time.Sleep(deadlockGrace)
+		s.mu.Lock()
+		if s.deadlocked() && s.wakeups == wakeups {
+			fmt.Fprintln(os.Stderr, "Deadlock: all threads are waiting")
+			s.dump(os.Stderr)
+			s.mu.Unlock()
+			s.ReportSeed(os.Stderr)
+			os.Exit(2)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// deadlocked reports whether no thread runs or can run, and none will
+// wake up by itself: they all wait on streams or mutexes, or for other
+// threads to finish. s.mu must be held.
+func (s *Scheduler) deadlocked() bool {
+	if len(s.idle) < len(s.procs) || atomic.LoadInt64(&s.runnable) > 0 {
+		return false
+	}
+	stuck := false
+	for _, t := range s.threads {
+		switch t.waiting {
+		case waitSend, waitReceive, waitSelect, waitLock:
+			stuck = true
+		case waitThreads:
+		default:
+			return false
+		}
+	}
+	return stuck
+}
+
+// ReportSeed prints how to replay the schedule in deterministic mode.
+func (s *Scheduler) ReportSeed(w io.Writer) {
+	if seed, ok := s.Seed(); ok {
+		fmt.Fprintf(w, "Scheduled with seed %d, replay with -seed %d\n", seed, seed)
+	}
+}
+
+// stopTimeout bounds how long Dump waits for running threads to stop.
+const stopTimeout = 100 * time.Millisecond
+
+// Dump writes every thread with its call stack to w. Running threads are
+// stopped at their next preemption point while the dump is written; one
+// that does not get there in time is listed without its stack.
+func (s *Scheduler) Dump(w io.Writer) {
+	s.dumpMu.Lock()
+	defer s.dumpMu.Unlock()
+
+	s.mu.Lock()
+	s.resume = make(chan struct{})
+	atomic.StoreInt32(&s.stopping, 1)
+	s.mu.Unlock()
+
+	deadline := time.Now().Add(stopTimeout)
+	for !s.stopped() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(w, "Thread dump:")
+	s.dump(w)
+	atomic.StoreInt32(&s.stopping, 0)
+	close(s.resume)
+}
+
+// stopped asks the running threads to stop, and reports whether all of
+// them did.
+func (s *Scheduler) stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stopped := true
+	for _, p := range s.procs {
+		p.mu.Lock()
+		if p.current != nil && !p.current.paused {
+			atomic.StoreInt32(&p.current.preempt, 1)
+			stopped = false
+		}
+		p.mu.Unlock()
+	}
+	return stopped
+}
+
+// checkStop stops the thread while a dump is written. It is called
+// whenever the thread may run up code again.
+func (t *Thread) checkStop() {
+	if atomic.LoadInt32(&t.sched.stopping) != 0 {
+		t.sched.pause(t)
+	}
+}
+
+func (s *Scheduler) pause(t *Thread) {
+	s.mu.Lock()
+	if atomic.LoadInt32(&s.stopping) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	resume := s.resume
+	t.paused = true
+	s.mu.Unlock()
+
+	<-resume
+	s.mu.Lock()
+	t.paused = false
+	s.mu.Unlock()
+}
+
+// dump writes the threads ordered by ID. s.mu must be held.
+func (s *Scheduler) dump(w io.Writer) {
+	running := map[*Thread]bool{}
+	for _, p := range s.procs {
+		p.mu.Lock()
+		if p.current != nil && !p.current.paused {
+			running[p.current] = true
+		}
+		p.mu.Unlock()
+	}
+
+	threads := make([]*Thread, 0, len(s.threads))
+	for _, t := range s.threads {
+		threads = append(threads, t)
+	}
+	sort.Slice(threads, func(i, j int) bool { return threads[i].ID < threads[j].ID })
+
+	for _, t := range threads {
+		fmt.Fprintf(w, "\nthread #%d %s", t.ID, t.Name)
+		switch {
+		case running[t]:
+			fmt.Fprintln(w, ", running (did not stop)")
+			continue
+		case t.waiting != notWaiting:
+			fmt.Fprintf(w, ", waiting (%s):\n", t.waiting)
+		case t.paused:
+			fmt.Fprintln(w, ", running:")
+		default:
+			fmt.Fprintln(w, ", runnable:")
+		}
+		for i := len(t.frames) - 1; i >= 0; i-- {
+			frame := t.frames[i]
+			if frame.Pos == (Position{}) {
+				fmt.Fprintf(w, "    %s\n", frame.Function)
+			} else {
+				fmt.Fprintf(w, "    %s at %s\n", frame.Function, frame.Pos)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package up
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// deadlocked waits on a stream that the only other thread never sends to,
+// because that thread waits on a stream nobody sends to either.
+const deadlocked = `func relay(s: stream, out: stream) -> nil {
+    out <- <-s
+}
+
+func main() -> nil {
+    s = stream()
+    out = stream()
+    up relay(s, out)
+    <-out
+}
+`
+
+// TestDeadlock runs a deadlocked program in a child process, since a
+// deadlock ends the process with exit status 2 and a dump of the threads.
+func TestDeadlock(t *testing.T) {
+	if os.Getenv("UP_TEST_DEADLOCK") == "1" {
+		Run(parseSource(t, deadlocked), NewEnvironment())
+		t.Fatal("the deadlocked program returned")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDeadlock$")
+	cmd.Env = append(os.Environ(), "UP_TEST_DEADLOCK=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 2 {
+		t.Fatalf("got %v, want exit status 2\n%s", err, stderr.String())
+	}
+
+	want := `Deadlock: all threads are waiting
+
+thread #0 main, waiting (stream receive):
+    main at [9:5]
+
+thread #1 relay(s, out), waiting (stream receive):
+    relay at [2:12]
+`
+	if stderr.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", stderr.String(), want)
+	}
+}
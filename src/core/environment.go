@@ -3,47 +3,375 @@ package up
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
+	"unsafe"
 )
 
+// Environment is a single scope. A scope is private to the thread running
+// it until a closure captures it; from then on other threads may read and
+// assign its variables, and it is shared. A shared scope publishes its
+// bindings in a map that is never changed in place: a new variable is
+// added to a copy, swapped in with compare-and-swap, and the values of the
+// bindings are loaded and stored atomically. Private scopes, the locals of
+// most calls, skip all of that.
 type Environment struct {
-	store map[string]interface{}
+	// vars holds the bindings while the scope is private.
+	vars map[string]*binding
+	// shared points to the map of bindings once the scope is shared.
+	shared unsafe.Pointer
+	// ints holds the typed int locals of a specialized function frame.
+	ints  []int
 	outer *Environment
+	// thread is the up thread running the scope.
+	thread  *Thread
+	threads *Scheduler
+	methods *MethodTable
 }
 
-type BuiltinFunction func(args []interface{}) interface{}
+// BuiltinFunction is a function implemented in Go. It reports failures by
+// panicking with a string, which becomes an up error at the call site.
+// thread is the calling thread, for builtins that block.
+type BuiltinFunction func(thread *Thread, args []Value) Value
+
+func (f BuiltinFunction) Type() *DataType {
+	return FuncType
+}
+
+func (f BuiltinFunction) String() string {
+	return "builtin func"
+}
 
 func NewEnvironment() *Environment {
-	s := make(map[string]interface{})
-	env := &Environment{store: s, outer: nil}
+	store := make(map[string]Value)
+	env := &Environment{vars: make(map[string]*binding), outer: nil, threads: NewScheduler(), methods: NewMethodTable()}
 	
 	// add built-in functions
-	env.store["print"] = BuiltinFunction(func(args []interface{}) interface{} {
+	store["print"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        // build the whole line first so output of concurrent threads is not interleaved
+        var sb strings.Builder
         for _, arg := range args {
-            fmt.Print(arg)
+            sb.WriteString(stringify(arg))
         }
-        fmt.Println() // newline after print
+        fmt.Println(sb.String()) // newline after print
         return nil
     })
-	
+	store["sleep"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        if len(args) != 1 {
+            panic(fmt.Sprintf("sleep expects 1 argument but got %d", len(args)))
+        }
+        d, ok := duration(args[0])
+        if !ok {
+            panic(fmt.Sprintf("sleep expects int or float seconds, but got: %s", TypeOf(args[0]).Name))
+        }
+        thread.Sleep(d)
+        return nil
+    })
+	store["close"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        if len(args) != 1 {
+            panic(fmt.Sprintf("close expects 1 argument but got %d", len(args)))
+        }
+        s, ok := args[0].(*Stream)
+        if !ok {
+            panic(fmt.Sprintf("close expects a stream, but got: %s", TypeOf(args[0]).Name))
+        }
+        if thread.Racing() {
+            s.sync.release(thread)
+        }
+        s.Close()
+        return nil
+    })
+	store["lock"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        mutexArgument("lock", args).Lock(thread)
+        return nil
+    })
+	store["unlock"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        mutexArgument("unlock", args).Unlock(thread)
+        return nil
+    })
+	store["len"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        if len(args) != 1 {
+            panic(fmt.Sprintf("len expects 1 argument but got %d", len(args)))
+        }
+        switch v := args[0].(type) {
+        case *List:
+            return Int(v.Len())
+        case String:
+            return Int(len(v))
+        case *Map:
+            return Int(v.Len())
+        case *Stream:
+            return Int(v.Len())
+        default:
+            panic(fmt.Sprintf("len expects a list, map, string or stream, but got: %s", TypeOf(args[0]).Name))
+        }
+    })
+	// append(xs, values...) appends in place and returns the list
+	store["append"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        list := listArgument("append", args, 1)
+        list.Append(args[1:]...)
+        return list
+    })
+	// pop(xs) removes the last element, pop(xs, i) the element at i
+	store["pop"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        list := listArgument("pop", args, 1)
+        index := list.Len() - 1
+        switch len(args) {
+        case 1:
+        case 2:
+            index = intArgument("pop", args[1])
+        default:
+            panic(fmt.Sprintf("pop expects 1 or 2 arguments but got %d", len(args)))
+        }
+        value, ok := list.Pop(index)
+        if !ok {
+            panic(fmt.Sprintf("pop index %d out of range for list of length %d", index, list.Len()))
+        }
+        return value
+    })
+	// insert(xs, i, value) inserts value before the element at i
+	store["insert"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        list := listArgument("insert", args, 3)
+        if len(args) != 3 {
+            panic(fmt.Sprintf("insert expects 3 arguments but got %d", len(args)))
+        }
+        index := intArgument("insert", args[1])
+        if !list.Insert(index, args[2]) {
+            panic(fmt.Sprintf("insert index %d out of range for list of length %d", index, list.Len()))
+        }
+        return list
+    })
+	store["has"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        m := mapArgument("has", args, 2)
+        _, ok := m.Get(args[1])
+        return Bool(ok)
+    })
+	// delete(m, key) removes key and reports whether it was present
+	store["delete"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        m := mapArgument("delete", args, 2)
+        return Bool(m.Delete(args[1]))
+    })
+	store["keys"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        keys, _ := mapArgument("keys", args, 1).Snapshot()
+        return NewList(keys)
+    })
+	store["values"] = BuiltinFunction(func(thread *Thread, args []Value) Value {
+        _, values := mapArgument("values", args, 1).Snapshot()
+        return NewList(values)
+    })
+	for _, t := range builtinTypes {
+		store[t.Name] = t
+	}
+	// str is the short name of the string type, e.g. str(42)
+	store["str"] = StringType
+	for name, value := range store {
+		env.vars[name] = &binding{value: value}
+	}
+	// the globals are visible to every thread
+	env.share()
+
 	return env
 }
 
-func (e *Environment) Get(name string) (interface{}, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+// listArgument checks that a builtin got at least minArgs arguments and a
+// list as the first one.
+func listArgument(name string, args []Value, minArgs int) *List {
+	if len(args) < minArgs {
+		panic(fmt.Sprintf("%s expects at least %d arguments but got %d", name, minArgs, len(args)))
+	}
+	list, ok := args[0].(*List)
+	if !ok {
+		panic(fmt.Sprintf("%s expects a list, but got: %s", name, TypeOf(args[0]).Name))
+	}
+	return list
+}
+
+// mapArgument checks that a builtin got exactly argc arguments and a map as
+// the first one.
+func mapArgument(name string, args []Value, argc int) *Map {
+	if len(args) != argc {
+		panic(fmt.Sprintf("%s expects %d arguments but got %d", name, argc, len(args)))
+	}
+	m, ok := args[0].(*Map)
+	if !ok {
+		panic(fmt.Sprintf("%s expects a map, but got: %s", name, TypeOf(args[0]).Name))
 	}
-	return obj, ok
+	return m
 }
 
-func (e *Environment) Set(name string, val interface{}) {
-	e.store[name] = val
+func intArgument(name string, arg Value) int {
+	i, ok := arg.(Int)
+	if !ok {
+		panic(fmt.Sprintf("%s expects an int index, but got: %s", name, TypeOf(arg).Name))
+	}
+	return int(i)
+}
+
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	return &Environment{vars: make(map[string]*binding), outer: outer, thread: outer.thread, threads: outer.threads, methods: outer.methods}
+}
+
+// Methods returns the table of methods declared with a receiver type.
+func (e *Environment) Methods() *MethodTable {
+	return e.methods
+}
+
+// Threads returns the scheduler that runs threads spawned with the up
+// keyword.
+func (e *Environment) Threads() *Scheduler {
+	return e.threads
+}
+
+// binding is a variable of a scope. In a private scope its value is a
+// plain field; sharing the scope moves it behind ref, which is swapped
+// atomically. The declared type never changes once the scope is shared:
+// declaring the variable again publishes a new binding.
+type binding struct {
+	value  Value
+	ref    unsafe.Pointer
+	typ    *TypeNode
+	shadow *Shadow
+}
+
+func (b *binding) load() Value {
+	if ref := atomic.LoadPointer(&b.ref); ref != nil {
+		return *(*Value)(ref)
+	}
+	return b.value
+}
+
+func (b *binding) store(val Value) {
+	if atomic.LoadPointer(&b.ref) == nil {
+		b.value = val
+		return
+	}
+	atomic.StorePointer(&b.ref, unsafe.Pointer(&val))
+}
+
+// bindings returns the current bindings of the scope. The map of a shared
+// scope must not be changed.
+func (e *Environment) bindings() map[string]*binding {
+	if shared := atomic.LoadPointer(&e.shared); shared != nil {
+		return *(*map[string]*binding)(shared)
+	}
+	return e.vars
+}
+
+func (e *Environment) binding(name string) *binding {
+	return e.bindings()[name]
+}
+
+// publish adds or replaces a binding of a shared scope. The bindings are
+// copied and swapped in, retrying when another thread published first.
+// A replaced variable keeps its shadow, so races across the redeclaration
+// are still found.
+func (e *Environment) publish(name string, val Value, t *TypeNode) {
+	b := &binding{ref: unsafe.Pointer(&val), typ: t}
+	for {
+		shared := atomic.LoadPointer(&e.shared)
+		old := *(*map[string]*binding)(shared)
+		if previous, ok := old[name]; ok {
+			b.shadow = previous.shadow
+		} else {
+			b.shadow = e.newShadow()
+		}
+		bindings := make(map[string]*binding, len(old)+1)
+		for k, v := range old {
+			bindings[k] = v
+		}
+		bindings[name] = b
+		if atomic.CompareAndSwapPointer(&e.shared, shared, unsafe.Pointer(&bindings)) {
+			return
+		}
+	}
+}
+
+// share makes e and the scopes enclosing it shared, before a closure
+// capturing them can reach another thread. It is called by the thread
+// running e.
+func (e *Environment) share() {
+	for scope := e; scope != nil && atomic.LoadPointer(&scope.shared) == nil; scope = scope.outer {
+		bindings := scope.vars
+		for _, b := range bindings {
+			value := b.value
+			b.value = nil
+			atomic.StorePointer(&b.ref, unsafe.Pointer(&value))
+		}
+		scope.vars = nil
+		atomic.StorePointer(&scope.shared, unsafe.Pointer(&bindings))
+	}
+}
+
+func (e *Environment) Get(name string) (Value, bool) {
+	for scope := e; scope != nil; scope = scope.outer {
+		if b := scope.binding(name); b != nil {
+			return b.load(), true
+		}
+	}
+	return nil, false
+}
+
+// Set defines or updates a local variable of e. A variable declared with
+// a type keeps it; callers check the value against it.
+func (e *Environment) Set(name string, val Value) {
+	if e.vars != nil {
+		if b, ok := e.vars[name]; ok {
+			b.value = val
+			return
+		}
+		e.vars[name] = &binding{value: val, shadow: e.newShadow()}
+		return
+	}
+	if b := e.binding(name); b != nil {
+		b.store(val)
+		return
+	}
+	e.publish(name, val, nil)
+}
+
+// Declare defines a local variable whose declared type is recorded with it,
+// so later assignments can be checked against that type.
+func (e *Environment) Declare(name string, val Value, t *TypeNode) {
+	if e.vars != nil {
+		if b, ok := e.vars[name]; ok {
+			b.value, b.typ = val, t
+			return
+		}
+		e.vars[name] = &binding{value: val, typ: t, shadow: e.newShadow()}
+		return
+	}
+	e.publish(name, val, t)
+}
+
+// DeclaredType returns the declared type of the variable that Assign would
+// update, or nil when that variable is untyped or does not exist yet.
+func (e *Environment) DeclaredType(name string) *TypeNode {
+	for scope := e; scope.outer != nil; scope = scope.outer {
+		if b := scope.binding(name); b != nil {
+			return b.typ
+		}
+	}
+	return nil
+}
+
+// Assign updates the variable in the nearest enclosing scope that already
+// defines it, so closures can change captured variables. Globals are never
+// overwritten this way; unknown names become locals of e.
+func (e *Environment) Assign(name string, val Value) {
+	for scope := e; scope.outer != nil; scope = scope.outer {
+		if b := scope.binding(name); b != nil {
+			b.store(val)
+			return
+		}
+	}
+	e.Set(name, val)
 }
 
 func (e *Environment) Visualize() {
+    bindings := e.bindings()
+
     // Calculate max length of variable name for nice formatting
     maxLen := 0
-    for name := range e.store {
+    for name := range bindings {
         if len(name) > maxLen {
             maxLen = len(name)
         }
@@ -55,8 +383,8 @@ func (e *Environment) Visualize() {
     fmt.Println("+", strings.Repeat("-", maxLen+2), "+------------------+")
 
     // Data
-    for name, value := range e.store {
-        displayValue := formatValue(value)
+    for name, b := range bindings {
+        displayValue := formatValue(b.load())
         fmt.Printf("| %-*s | %14s |\n", maxLen, name, displayValue)
     }
 
@@ -70,15 +398,13 @@ func (e *Environment) Visualize() {
     }
 }
 
-func formatValue(value interface{}) string {
+func formatValue(value Value) string {
     switch v := value.(type) {
-    case *FuncDeclarationNode:
+    case Callable:
         // Return function signature instead of full body
-        return fmt.Sprintf("func %s(...)", v.Name)
-    case func(...interface{}) interface{}:  // For built-in functions
-        return "builtin func"
+        return fmt.Sprintf("func %s(...)", v.Name())
     default:
-        strVal := fmt.Sprintf("%v", value)
+        strVal := stringify(value)
         // Truncate long values to fit in table
         if len(strVal) > 12 {
             return strVal[:9] + "..."
@@ -0,0 +1,83 @@
+package up
+
+import "fmt"
+
+// ErrorValue is what `throw` raises and `catch` receives. Runtime failures
+// of the interpreter itself are raised as ErrorValues too, so that scripts
+// can catch them like any other error.
+type ErrorValue struct {
+	Message string
+	// Value is the thrown value when something other than an error was
+	// thrown, e.g. the string of `throw "message"`.
+	Value Value
+	Pos   Position
+}
+
+var ErrorType = &DataType{Name: "error"}
+
+func NewRuntimeError(pos Position, format string, args ...interface{}) *ErrorValue {
+	return &ErrorValue{Message: fmt.Sprintf(format, args...), Pos: pos}
+}
+
+func (e *ErrorValue) Type() *DataType {
+	return ErrorType
+}
+
+func (e *ErrorValue) String() string {
+	return e.Error()
+}
+
+func (e *ErrorValue) Error() string {
+	if e.Pos == (Position{}) {
+		return e.Message
+	}
+	return e.Message + " at " + e.Pos.String()
+}
+
+// constructError backs the error type when it is called as a function.
+func constructError(args []Value) *ErrorValue {
+	if len(args) != 1 {
+		panic(fmt.Sprintf("error expects 1 argument but got %d", len(args)))
+	}
+	return &ErrorValue{Message: stringify(args[0])}
+}
+
+// Throw raises value as an up error at pos.
+func Throw(value Value, pos Position) {
+	if err, ok := value.(*ErrorValue); ok {
+		if err.Pos == (Position{}) {
+			thrown := *err
+			thrown.Pos = pos
+			panic(&thrown)
+		}
+		panic(err)
+	}
+	panic(&ErrorValue{Message: stringify(value), Value: value, Pos: pos})
+}
+
+// RethrowAt is deferred around Go helpers (builtins, streams) that report
+// failures with a plain string panic, and turns them into up errors at pos.
+func RethrowAt(pos Position) {
+	if r := recover(); r != nil {
+		if message, ok := r.(string); ok {
+			panic(&ErrorValue{Message: message, Pos: pos})
+		}
+		panic(r)
+	}
+}
+
+// Run executes a program on the main thread and returns an uncaught up
+// error instead of panicking with it.
+func Run(program *ProgramNode, env *Environment) (result Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if errValue, ok := r.(*ErrorValue); ok {
+				err = errValue
+				return
+			}
+			panic(r)
+		}
+	}()
+	env.thread = env.Threads().Main()
+	return ExecuteNode(program, env), nil
+}
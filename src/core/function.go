@@ -0,0 +1,43 @@
+package up
+
+// Callable is a function value written in up. The tree-walker's Function
+// and the closures of the bytecode VM both implement it.
+type Callable interface {
+	Value
+	Name() string
+	Arity() int
+}
+
+// Function is a function value: the declaration together with the
+// environment it was declared in, so that its body resolves free
+// variables lexically instead of through the caller.
+type Function struct {
+	Decl *FuncDeclarationNode
+	Env  *Environment
+	// This is the receiving value of a method looked up on a value.
+	This Value
+}
+
+// Bind returns the method f bound to the receiving value.
+func (f *Function) Bind(this Value) *Function {
+	return &Function{Decl: f.Decl, Env: f.Env, This: this}
+}
+
+func (f *Function) Name() string {
+	if f.Decl.Name == "" {
+		return "<anonymous>"
+	}
+	return f.Decl.Name
+}
+
+func (f *Function) Arity() int {
+	return len(f.Decl.Parameters)
+}
+
+func (f *Function) Type() *DataType {
+	return FuncType
+}
+
+func (f *Function) String() string {
+	return f.Decl.Signature()
+}
@@ -9,13 +9,18 @@ type TokenType string
 
 const (
 	FUNC        TokenType = "FUNC"
+	CLASS       TokenType = "CLASS"
     LBRACE      TokenType = "LBRACE"
     RBRACE      TokenType = "RBRACE"
+	LBRACKET    TokenType = "LBRACKET"
+	RBRACKET    TokenType = "RBRACKET"
 	LPAREN      TokenType = "LPAREN"
 	RPAREN      TokenType = "RPAREN"
 	COLON       TokenType = "COLON"
 	COMMA       TokenType = "COMMA"
+	DOT         TokenType = "DOT"
 	ARROW       TokenType = "ARROW"
+	LARROW      TokenType = "LARROW"
 	IDENTIFIER  TokenType = "IDENTIFIER"
 	FLOAT       TokenType = "FLOAT"
 	INT         TokenType = "INT"
@@ -23,17 +28,41 @@ const (
 	SUB         TokenType = "SUB"
 	MUL         TokenType = "MUL"
 	DIV         TokenType = "DIV"
+	MOD         TokenType = "MOD"
+	LT          TokenType = "LT"
+	GT          TokenType = "GT"
+	LTE         TokenType = "LTE"
+	GTE         TokenType = "GTE"
+	EQ          TokenType = "EQ"
+	NOT_EQ      TokenType = "NOT_EQ"
+	AND         TokenType = "AND"
+	OR          TokenType = "OR"
+	NOT         TokenType = "NOT"
 	ADD_ASSIGN  TokenType = "ADD_ASSIGN"
 	SUB_ASSIGN  TokenType = "SUB_ASSIGN"
 	MUL_ASSIGN  TokenType = "MUL_ASSIGN"
 	DIV_ASSIGN  TokenType = "DIV_ASSIGN"
+	MOD_ASSIGN  TokenType = "MOD_ASSIGN"
 	RETURN      TokenType = "RETURN"
+	IF          TokenType = "IF"
+	ELSE        TokenType = "ELSE"
+	TRUE        TokenType = "TRUE"
+	FALSE       TokenType = "FALSE"
+	NIL         TokenType = "NIL"
 	ASSIGN      TokenType = "ASSIGN"
 	FOR         TokenType = "FOR"
 	IN          TokenType = "IN"
 	RANGE       TokenType = "RANGE"
 	STRING      TokenType = "STRING"
 	MAIN        TokenType = "MAIN"
+	UP          TokenType = "UP"
+	SELECT      TokenType = "SELECT"
+	CASE        TokenType = "CASE"
+	DEFAULT     TokenType = "DEFAULT"
+	TIMEOUT     TokenType = "TIMEOUT"
+	THROW       TokenType = "THROW"
+	TRY         TokenType = "TRY"
+	CATCH       TokenType = "CATCH"
 	EOF         TokenType = "EOF"
 	ENDFUNC     TokenType = "ENDFUNC"
 	ENDFOR      TokenType = "ENDFOR"
@@ -46,10 +75,34 @@ type Token struct {
 	Col   int
 }
 
+func (t Token) Pos() Position {
+	return Position{Row: t.Row, Col: t.Col}
+}
+
 func isAlpha(ch byte) bool {
 	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
 }
 
+// exponentLength returns the length of the exponent of a float literal at
+// the start of input, e.g. 3 for "e-5", or 0 when there is none.
+func exponentLength(input string) int {
+	if len(input) == 0 || (input[0] != 'e' && input[0] != 'E') {
+		return 0
+	}
+	i := 1
+	if i < len(input) && (input[i] == '+' || input[i] == '-') {
+		i++
+	}
+	digits := i
+	for i < len(input) && isDigit(input[i]) {
+		i++
+	}
+	if i == digits {
+		return 0
+	}
+	return i
+}
+
 func isDigit(ch byte) bool {
 	return ch >= '0' && ch <= '9'
 }
@@ -63,15 +116,27 @@ func Lexer(input string) ([]Token, error) {
 		switch {
 		case isAlpha(input[i]) || input[i] == '_':
 			start := i
-			for isAlpha(input[i]) || isDigit(input[i]) || input[i] == '_' {
+			for i < len(input) && (isAlpha(input[i]) || isDigit(input[i]) || input[i] == '_') {
 				i++
 			}
 			identifier := input[start:i]
 			switch identifier {
 				case "func":
 					tokens = append(tokens, Token{Type: FUNC, Value: "func", Row: row, Col: col})
+				case "class":
+					tokens = append(tokens, Token{Type: CLASS, Value: "class", Row: row, Col: col})
 				case "return":
 					tokens = append(tokens, Token{Type: RETURN, Value: "return", Row: row, Col: col})
+				case "if":
+					tokens = append(tokens, Token{Type: IF, Value: "if", Row: row, Col: col})
+				case "else":
+					tokens = append(tokens, Token{Type: ELSE, Value: "else", Row: row, Col: col})
+				case "true":
+					tokens = append(tokens, Token{Type: TRUE, Value: "true", Row: row, Col: col})
+				case "false":
+					tokens = append(tokens, Token{Type: FALSE, Value: "false", Row: row, Col: col})
+				case "nil":
+					tokens = append(tokens, Token{Type: NIL, Value: "nil", Row: row, Col: col})
 				case "for":
 					tokens = append(tokens, Token{Type: FOR, Value: "for", Row: row, Col: col})
 				case "in":
@@ -80,9 +145,26 @@ func Lexer(input string) ([]Token, error) {
 					tokens = append(tokens, Token{Type: RANGE, Value: "range", Row: row, Col: col})
 				case "main":
 					tokens = append(tokens, Token{Type: MAIN, Value: "main", Row: row, Col: col})
+				case "up":
+					tokens = append(tokens, Token{Type: UP, Value: "up", Row: row, Col: col})
+				case "select":
+					tokens = append(tokens, Token{Type: SELECT, Value: "select", Row: row, Col: col})
+				case "case":
+					tokens = append(tokens, Token{Type: CASE, Value: "case", Row: row, Col: col})
+				case "default":
+					tokens = append(tokens, Token{Type: DEFAULT, Value: "default", Row: row, Col: col})
+				case "timeout":
+					tokens = append(tokens, Token{Type: TIMEOUT, Value: "timeout", Row: row, Col: col})
+				case "throw":
+					tokens = append(tokens, Token{Type: THROW, Value: "throw", Row: row, Col: col})
+				case "try":
+					tokens = append(tokens, Token{Type: TRY, Value: "try", Row: row, Col: col})
+				case "catch":
+					tokens = append(tokens, Token{Type: CATCH, Value: "catch", Row: row, Col: col})
 				default:
 					tokens = append(tokens, Token{Type: IDENTIFIER, Value: identifier, Row: row, Col: col})
 				}
+				col += (i - start)
 			case input[i] == '/' && i+1 < len(input) && input[i+1] == '/':
 				i += 2
 				for i < len(input) && input[i] != '\n' {
@@ -98,10 +180,19 @@ func Lexer(input string) ([]Token, error) {
 			case strings.HasPrefix(input[i:], "->"):
 				tokens = append(tokens, Token{Type: ARROW, Value: "->", Row: row, Col: col})
 				i += 2
+				col += 2
+			case strings.HasPrefix(input[i:], "<-"):
+				tokens = append(tokens, Token{Type: LARROW, Value: "<-", Row: row, Col: col})
+				i += 2
+				col += 2
 			case input[i] == ':':
 				tokens = append(tokens, Token{Type: COLON, Value: ":", Row: row, Col: col})
 				i++
 				col++
+			case input[i] == '.':
+				tokens = append(tokens, Token{Type: DOT, Value: ".", Row: row, Col: col})
+				i++
+				col++
 			case input[i] == ',':
 				tokens = append(tokens, Token{Type: COMMA, Value: ",", Row: row, Col: col})
 				i++
@@ -114,6 +205,14 @@ func Lexer(input string) ([]Token, error) {
 				tokens = append(tokens, Token{Type: RBRACE, Value: "}", Row: row, Col: col})
 				i++
 				col++
+			case input[i] == '[':
+				tokens = append(tokens, Token{Type: LBRACKET, Value: "[", Row: row, Col: col})
+				i++
+				col++
+			case input[i] == ']':
+				tokens = append(tokens, Token{Type: RBRACKET, Value: "]", Row: row, Col: col})
+				i++
+				col++
 			case input[i] == '(':
 				tokens = append(tokens, Token{Type: LPAREN, Value: "(", Row: row, Col: col})
 				i++
@@ -138,20 +237,64 @@ func Lexer(input string) ([]Token, error) {
 				tokens = append(tokens, Token{Type: DIV_ASSIGN, Value: "/=", Row: row, Col: col})
 				i += 2
 				col += 2
+			case strings.HasPrefix(input[i:], "%="):
+				tokens = append(tokens, Token{Type: MOD_ASSIGN, Value: "%=", Row: row, Col: col})
+				i += 2
+				col += 2
 			case input[i] == '+':
-				tokens = append(tokens, Token{Type: ADD, Value: "+"})
+				tokens = append(tokens, Token{Type: ADD, Value: "+", Row: row, Col: col})
 				i++
 				col++
 			case input[i] == '-':
-				tokens = append(tokens, Token{Type: SUB, Value: "-"})
+				tokens = append(tokens, Token{Type: SUB, Value: "-", Row: row, Col: col})
 				i++
 				col++
 			case input[i] == '*':
-				tokens = append(tokens, Token{Type: MUL, Value: "*"})
+				tokens = append(tokens, Token{Type: MUL, Value: "*", Row: row, Col: col})
 				i++
 				col++
 			case input[i] == '/':
-				tokens = append(tokens, Token{Type: DIV, Value: "/"})
+				tokens = append(tokens, Token{Type: DIV, Value: "/", Row: row, Col: col})
+				i++
+				col++
+			case input[i] == '%':
+				tokens = append(tokens, Token{Type: MOD, Value: "%", Row: row, Col: col})
+				i++
+				col++
+			case strings.HasPrefix(input[i:], "=="):
+				tokens = append(tokens, Token{Type: EQ, Value: "==", Row: row, Col: col})
+				i += 2
+				col += 2
+			case strings.HasPrefix(input[i:], "!="):
+				tokens = append(tokens, Token{Type: NOT_EQ, Value: "!=", Row: row, Col: col})
+				i += 2
+				col += 2
+			case strings.HasPrefix(input[i:], "<="):
+				tokens = append(tokens, Token{Type: LTE, Value: "<=", Row: row, Col: col})
+				i += 2
+				col += 2
+			case strings.HasPrefix(input[i:], ">="):
+				tokens = append(tokens, Token{Type: GTE, Value: ">=", Row: row, Col: col})
+				i += 2
+				col += 2
+			case strings.HasPrefix(input[i:], "&&"):
+				tokens = append(tokens, Token{Type: AND, Value: "&&", Row: row, Col: col})
+				i += 2
+				col += 2
+			case strings.HasPrefix(input[i:], "||"):
+				tokens = append(tokens, Token{Type: OR, Value: "||", Row: row, Col: col})
+				i += 2
+				col += 2
+			case input[i] == '<':
+				tokens = append(tokens, Token{Type: LT, Value: "<", Row: row, Col: col})
+				i++
+				col++
+			case input[i] == '>':
+				tokens = append(tokens, Token{Type: GT, Value: ">", Row: row, Col: col})
+				i++
+				col++
+			case input[i] == '!':
+				tokens = append(tokens, Token{Type: NOT, Value: "!", Row: row, Col: col})
 				i++
 				col++
 			case input[i] == '=':
@@ -160,10 +303,24 @@ func Lexer(input string) ([]Token, error) {
 				col++
 			case isDigit(input[i]):
 				start := i
-				for isDigit(input[i]) {
+				tokenType := INT
+				for i < len(input) && isDigit(input[i]) {
 					i++
 				}
-				tokens = append(tokens, Token{Type: INT, Value: input[start:i], Row: row, Col: col})
+				// a fraction needs a digit after the dot, so 1.sum() is
+				// still a method call on an int.
+				if i+1 < len(input) && input[i] == '.' && isDigit(input[i+1]) {
+					tokenType = FLOAT
+					i++
+					for i < len(input) && isDigit(input[i]) {
+						i++
+					}
+				}
+				if exponent := exponentLength(input[i:]); exponent > 0 {
+					tokenType = FLOAT
+					i += exponent
+				}
+				tokens = append(tokens, Token{Type: tokenType, Value: input[start:i], Row: row, Col: col})
 				col += (i - start)
 			case input[i] == '"':
 				start := i
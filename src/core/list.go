@@ -0,0 +1,105 @@
+package up
+
+import (
+	"strings"
+	"sync"
+)
+
+// List is the mutable list value. Lists are passed by reference and may be
+// shared between threads, so every access is guarded.
+type List struct {
+	mu       sync.RWMutex
+	elements []Value
+}
+
+var ListType = &DataType{Name: "list"}
+
+func NewList(elements []Value) *List {
+	return &List{elements: elements}
+}
+
+func (l *List) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.elements)
+}
+
+func (l *List) Get(index int) (Value, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if index < 0 || index >= len(l.elements) {
+		return nil, false
+	}
+	return l.elements[index], true
+}
+
+func (l *List) Set(index int, value Value) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if index < 0 || index >= len(l.elements) {
+		return false
+	}
+	l.elements[index] = value
+	return true
+}
+
+func (l *List) Append(values ...Value) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.elements = append(l.elements, values...)
+}
+
+// Pop removes and returns the element at index.
+func (l *List) Pop(index int) (Value, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if index < 0 || index >= len(l.elements) {
+		return nil, false
+	}
+	value := l.elements[index]
+	l.elements = append(l.elements[:index], l.elements[index+1:]...)
+	return value, true
+}
+
+// Insert puts value before index; index may be the length to append.
+func (l *List) Insert(index int, value Value) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if index < 0 || index > len(l.elements) {
+		return false
+	}
+	l.elements = append(l.elements, nil)
+	copy(l.elements[index+1:], l.elements[index:])
+	l.elements[index] = value
+	return true
+}
+
+// Slice returns a new list with the elements in [low, high).
+func (l *List) Slice(low, high int) (*List, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if low < 0 || high > len(l.elements) || low > high {
+		return nil, false
+	}
+	return NewList(append([]Value{}, l.elements[low:high]...)), true
+}
+
+// Snapshot returns a copy of the elements, e.g. for iterating while other
+// threads modify the list.
+func (l *List) Snapshot() []Value {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]Value{}, l.elements...)
+}
+
+func (l *List) Type() *DataType {
+	return ListType
+}
+
+func (l *List) String() string {
+	elementStrs := []string{}
+	for _, element := range l.Snapshot() {
+		elementStrs = append(elementStrs, stringify(element))
+	}
+	return "[" + strings.Join(elementStrs, ", ") + "]"
+}
@@ -0,0 +1,128 @@
+package up
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Map is the mutable map value. Keys are found by Hash and Equal, so 1 and
+// 1.0 are the same key. It keeps its keys in insertion order and guards
+// every access, so a map can be shared between threads.
+type Map struct {
+	mu      sync.RWMutex
+	buckets map[uint64][]*mapEntry
+	order   []*mapEntry
+}
+
+type mapEntry struct {
+	key   Value
+	value Value
+}
+
+var MapType = &DataType{Name: "map"}
+
+func NewMap() *Map {
+	return &Map{buckets: make(map[uint64][]*mapEntry)}
+}
+
+// checkKey rejects keys that cannot be compared by value.
+func checkKey(key Value) uint64 {
+	if !Hashable(key) {
+		panic(fmt.Sprintf("Invalid map key type: %s", TypeOf(key).Name))
+	}
+	return Hash(key)
+}
+
+// find returns the entry of key. The caller holds the lock.
+func (m *Map) find(hash uint64, key Value) *mapEntry {
+	for _, entry := range m.buckets[hash] {
+		if Equal(entry.key, key) {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (m *Map) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.order)
+}
+
+func (m *Map) Get(key Value) (Value, bool) {
+	hash := checkKey(key)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if entry := m.find(hash, key); entry != nil {
+		return entry.value, true
+	}
+	return nil, false
+}
+
+func (m *Map) Set(key Value, value Value) {
+	hash := checkKey(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry := m.find(hash, key); entry != nil {
+		entry.value = value
+		return
+	}
+	entry := &mapEntry{key: key, value: value}
+	m.buckets[hash] = append(m.buckets[hash], entry)
+	m.order = append(m.order, entry)
+}
+
+func (m *Map) Delete(key Value) bool {
+	hash := checkKey(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.find(hash, key)
+	if entry == nil {
+		return false
+	}
+	bucket := m.buckets[hash]
+	for i, e := range bucket {
+		if e == entry {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(m.buckets, hash)
+	} else {
+		m.buckets[hash] = bucket
+	}
+	for i, e := range m.order {
+		if e == entry {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Snapshot returns the keys and values in insertion order.
+func (m *Map) Snapshot() (keys []Value, values []Value) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys = make([]Value, len(m.order))
+	values = make([]Value, len(m.order))
+	for i, entry := range m.order {
+		keys[i], values[i] = entry.key, entry.value
+	}
+	return keys, values
+}
+
+func (m *Map) Type() *DataType {
+	return MapType
+}
+
+func (m *Map) String() string {
+	keys, values := m.Snapshot()
+	entryStrs := []string{}
+	for i, key := range keys {
+		entryStrs = append(entryStrs, stringify(key)+": "+stringify(values[i]))
+	}
+	return "{" + strings.Join(entryStrs, ", ") + "}"
+}
@@ -0,0 +1,30 @@
+package up
+
+import "sync"
+
+// MethodTable holds the methods declared with a receiver, per type name.
+// Declarations may run on any thread, so the table is guarded.
+type MethodTable struct {
+	mu      sync.RWMutex
+	methods map[string]map[string]*Function
+}
+
+func NewMethodTable() *MethodTable {
+	return &MethodTable{methods: make(map[string]map[string]*Function)}
+}
+
+func (t *MethodTable) Define(typeName string, name string, method *Function) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.methods[typeName] == nil {
+		t.methods[typeName] = make(map[string]*Function)
+	}
+	t.methods[typeName][name] = method
+}
+
+func (t *MethodTable) Lookup(typeName string, name string) (*Function, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	method, ok := t.methods[typeName][name]
+	return method, ok
+}
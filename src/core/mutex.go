@@ -0,0 +1,65 @@
+package up
+
+import "fmt"
+
+// Mutex is a lock shared by up threads. It is a stream holding a token
+// while the mutex is locked, so a thread waiting for it gives up its
+// processor like any other stream operation.
+type Mutex struct {
+	token *Stream
+	sync  syncClock
+}
+
+var MutexType = &DataType{Name: "mutex"}
+
+func NewMutex() *Mutex {
+	return &Mutex{token: NewStream(AnyType, 1)}
+}
+
+func constructMutex(args []Value) *Mutex {
+	if len(args) != 0 {
+		panic(fmt.Sprintf("mutex expects no arguments but got %d", len(args)))
+	}
+	return NewMutex()
+}
+
+// Lock waits until the mutex is unlocked and locks it.
+func (m *Mutex) Lock(thread *Thread) {
+	m.token.put(thread, nil, waitLock)
+	if thread.Racing() {
+		m.sync.acquire(thread)
+	}
+}
+
+// Unlock unlocks the mutex, which any thread may do.
+func (m *Mutex) Unlock(thread *Thread) {
+	if thread.Racing() {
+		m.sync.release(thread)
+	}
+	if !m.token.take(thread) {
+		panic("Unlock of unlocked mutex")
+	}
+}
+
+func (m *Mutex) Type() *DataType {
+	return MutexType
+}
+
+func (m *Mutex) String() string {
+	if m.token.Len() > 0 {
+		return "mutex(locked)"
+	}
+	return "mutex"
+}
+
+// mutexArgument checks that a builtin got a single mutex.
+func mutexArgument(name string, args []Value) *Mutex {
+	if len(args) != 1 {
+		panic(fmt.Sprintf("%s expects 1 argument but got %d", name, len(args)))
+	}
+	m, ok := args[0].(*Mutex)
+	if !ok {
+		panic(fmt.Sprintf("%s expects a mutex, but got: %s", name, TypeOf(args[0]).Name))
+	}
+	return m
+}
@@ -0,0 +1,103 @@
+package up
+
+import (
+	"math"
+	"strconv"
+)
+
+// Numbers in up are 64-bit ints and 64-bit IEEE floats. Int arithmetic
+// that overflows raises an error instead of wrapping around, while float
+// arithmetic follows IEEE 754: dividing a float by zero gives +Inf, -Inf
+// or NaN. An int operand combined with a float is promoted to float.
+
+const minInt = -1 << (strconv.IntSize - 1)
+
+// intArithmetic applies an arithmetic operator to two ints.
+func intArithmetic(op string, left, right int, pos Position) int {
+	switch op {
+	case "+":
+		sum := left + right
+		if (sum > left) != (right > 0) {
+			panic(NewRuntimeError(pos, "Integer overflow: %d + %d", left, right))
+		}
+		return sum
+	case "-":
+		difference := left - right
+		if (difference < left) != (right > 0) {
+			panic(NewRuntimeError(pos, "Integer overflow: %d - %d", left, right))
+		}
+		return difference
+	case "*":
+		product := left * right
+		if left != 0 && (product/left != right || left == -1 && right == minInt) {
+			panic(NewRuntimeError(pos, "Integer overflow: %d * %d", left, right))
+		}
+		return product
+	case "/":
+		if right == 0 {
+			panic(NewRuntimeError(pos, "Division by zero"))
+		}
+		if left == minInt && right == -1 {
+			panic(NewRuntimeError(pos, "Integer overflow: %d / %d", left, right))
+		}
+		return left / right
+	case "%":
+		if right == 0 {
+			panic(NewRuntimeError(pos, "Division by zero"))
+		}
+		return left % right
+	default:
+		panic(NewRuntimeError(pos, "Unknown operator: %s", op))
+	}
+}
+
+// negateInt is unary minus on an int.
+func negateInt(value int, pos Position) int {
+	if value == minInt {
+		panic(NewRuntimeError(pos, "Integer overflow: -(%d)", value))
+	}
+	return -value
+}
+
+// floatArithmetic applies an arithmetic operator to two floats.
+func floatArithmetic(op string, left, right float64, pos Position) float64 {
+	switch op {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	case "/":
+		return left / right
+	case "%":
+		return math.Mod(left, right)
+	default:
+		panic(NewRuntimeError(pos, "Unknown operator: %s", op))
+	}
+}
+
+// floatOperands returns both operands as floats when they are numbers and
+// at least one of them is a float.
+func floatOperands(left, right Value) (float64, float64, bool) {
+	l, lFloat, lOk := numberValue(left)
+	r, rFloat, rOk := numberValue(right)
+	return l, r, lOk && rOk && (lFloat || rFloat)
+}
+
+func byteToInt(value Value) Value {
+	if b, ok := value.(Byte); ok {
+		return Int(b)
+	}
+	return value
+}
+
+func numberValue(value Value) (number float64, isFloat bool, ok bool) {
+	switch v := value.(type) {
+	case Int:
+		return float64(v), false, true
+	case Float:
+		return float64(v), true, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,372 @@
+package up
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// The operations below work on evaluated values. They are shared by the
+// tree-walking interpreter and the bytecode VM, so that both engines give
+// the same results and report the same errors. Where an error message
+// quotes source code, the caller passes the text of that expression.
+
+// BinaryOp applies an arithmetic, comparison or equality operator. The
+// logical operators && and || only evaluate their right side when needed,
+// so the engines implement them themselves.
+func BinaryOp(op string, left, right Value, pos Position) Value {
+	switch op {
+	case "==":
+		return Bool(Equal(left, right))
+	case "!=":
+		return Bool(!Equal(left, right))
+	}
+
+	// a byte is an int in arithmetic and comparisons
+	left, right = byteToInt(left), byteToInt(right)
+
+	// Integer operations
+	if lInt, lOk := left.(Int); lOk {
+		if rInt, rOk := right.(Int); rOk {
+			switch op {
+			case "+", "-", "*", "/", "%":
+				return Int(intArithmetic(op, int(lInt), int(rInt), pos))
+			case "<":
+				return Bool(lInt < rInt)
+			case ">":
+				return Bool(lInt > rInt)
+			case "<=":
+				return Bool(lInt <= rInt)
+			case ">=":
+				return Bool(lInt >= rInt)
+			default:
+				panic(NewRuntimeError(pos, "Unknown operator: %s", op))
+			}
+		}
+	}
+
+	// float operations, with int operands promoted to float
+	if lFloat, rFloat, ok := floatOperands(left, right); ok {
+		switch op {
+		case "+", "-", "*", "/", "%":
+			return Float(floatArithmetic(op, lFloat, rFloat, pos))
+		case "<":
+			return Bool(lFloat < rFloat)
+		case ">":
+			return Bool(lFloat > rFloat)
+		case "<=":
+			return Bool(lFloat <= rFloat)
+		case ">=":
+			return Bool(lFloat >= rFloat)
+		default:
+			panic(NewRuntimeError(pos, "Unknown operator: %s", op))
+		}
+	}
+
+	// + with a string on either side concatenates the string form of
+	// the other operand
+	if op == "+" {
+		lStr, lOk := left.(String)
+		rStr, rOk := right.(String)
+		if lOk && !rOk {
+			return lStr + String(stringify(right))
+		}
+		if rOk && !lOk {
+			return String(stringify(left)) + rStr
+		}
+	}
+
+	// string operations
+	if lStr, lOk := left.(String); lOk {
+		if rStr, rOk := right.(String); rOk {
+			switch op {
+			case "+":
+				return lStr + rStr
+			case "<":
+				return Bool(lStr < rStr)
+			case ">":
+				return Bool(lStr > rStr)
+			case "<=":
+				return Bool(lStr <= rStr)
+			case ">=":
+				return Bool(lStr >= rStr)
+			default:
+				panic(NewRuntimeError(pos, "Invalid operation between strings: %s", op))
+			}
+		}
+	}
+
+	panic(NewRuntimeError(pos, "Invalid operation %s between %s and %s", op, TypeOf(left).Name, TypeOf(right).Name))
+}
+
+// UnaryOp applies the prefix operators ! and -.
+func UnaryOp(op string, operand Value, pos Position) Value {
+	switch op {
+	case "!":
+		return Bool(!Truthy(operand))
+	case "-":
+		switch v := operand.(type) {
+		case Int:
+			return Int(negateInt(int(v), pos))
+		case Float:
+			return -v
+		case Byte:
+			return -Int(v)
+		}
+		panic(NewRuntimeError(pos, "Invalid operand for unary -: %s", TypeOf(operand).Name))
+	default:
+		panic(NewRuntimeError(pos, "Unknown operator: %s", op))
+	}
+}
+
+// indexValue checks that a list or string index is an int.
+func indexValue(index Value, source string, pos Position) int {
+	i, ok := index.(Int)
+	if !ok {
+		panic(NewRuntimeError(pos, "Index must be an int: %s", source))
+	}
+	return int(i)
+}
+
+// Index returns object[index]. source is the index expression.
+func Index(object, index Value, source string, pos Position) Value {
+	if m, ok := object.(*Map); ok {
+		defer RethrowAt(pos)
+		value, ok := m.Get(index)
+		if !ok {
+			panic(fmt.Sprintf("Key %s not found in map", stringify(index)))
+		}
+		return value
+	}
+
+	i := indexValue(index, source, pos)
+	switch o := object.(type) {
+	case *List:
+		value, ok := o.Get(i)
+		if !ok {
+			panic(NewRuntimeError(pos, "Index %d out of range for list of length %d", i, o.Len()))
+		}
+		return value
+	case String:
+		if i < 0 || i >= len(o) {
+			panic(NewRuntimeError(pos, "Index %d out of range for string of length %d", i, len(o)))
+		}
+		return Byte(o[i])
+	default:
+		panic(NewRuntimeError(pos, "Cannot index %s", TypeOf(object).Name))
+	}
+}
+
+// SetIndex stores value at object[index]. source is the index expression.
+func SetIndex(object, index, value Value, source string, pos Position) {
+	switch o := object.(type) {
+	case *List:
+		i := indexValue(index, source, pos)
+		if !o.Set(i, value) {
+			panic(NewRuntimeError(pos, "Index %d out of range for list of length %d", i, o.Len()))
+		}
+	case *Map:
+		defer RethrowAt(pos)
+		o.Set(index, value)
+	default:
+		panic(NewRuntimeError(pos, "Cannot assign to element of %s", TypeOf(object).Name))
+	}
+}
+
+// Slice returns object[low:high]. An omitted bound has an empty source
+// and defaults to the start or the end.
+func Slice(object, low, high Value, lowSource, highSource string, pos Position) Value {
+	var length int
+	switch o := object.(type) {
+	case *List:
+		length = o.Len()
+	case String:
+		length = len(o)
+	default:
+		panic(NewRuntimeError(pos, "Cannot slice %s", TypeOf(object).Name))
+	}
+
+	lowIndex, highIndex := 0, length
+	if lowSource != "" {
+		lowIndex = indexValue(low, lowSource, pos)
+	}
+	if highSource != "" {
+		highIndex = indexValue(high, highSource, pos)
+	}
+
+	switch o := object.(type) {
+	case *List:
+		if sliced, ok := o.Slice(lowIndex, highIndex); ok {
+			return sliced
+		}
+	case String:
+		if lowIndex >= 0 && highIndex <= len(o) && lowIndex <= highIndex {
+			return o[lowIndex:highIndex]
+		}
+	}
+	panic(NewRuntimeError(pos, "Slice [%d:%d] out of range for length %d", lowIndex, highIndex, length))
+}
+
+// GetField reads a field of an object. source is the object expression.
+func GetField(object Value, field string, source string, pos Position) Value {
+	obj, ok := object.(*Object)
+	if !ok {
+		panic(NewRuntimeError(pos, "Cannot access field %s of non-object %s", field, source))
+	}
+	value, ok := obj.Get(field)
+	if !ok {
+		panic(NewRuntimeError(pos, "%s has no field %s", obj.Type().Name, field))
+	}
+	return value
+}
+
+// SetField updates a field of an object after checking the value against
+// the declared field type. source is the object expression.
+func SetField(object Value, field string, value Value, source string, pos Position) {
+	obj, ok := object.(*Object)
+	if !ok {
+		panic(NewRuntimeError(pos, "Cannot assign field %s of non-object %s", field, source))
+	}
+	class := obj.Type()
+	declared := class.Class.Field(field)
+	if declared == nil {
+		panic(NewRuntimeError(pos, "%s has no field %s", class.Name, field))
+	}
+	CheckType(declared.Type, value, class.Class.Env, pos, "field "+class.Name+"."+field)
+	obj.Set(field, value)
+}
+
+// SelectCase is one communication of a select statement: a send of Value
+// to Stream, or a receive from it.
+type SelectCase struct {
+	Stream *Stream
+	Send   bool
+	Value  Value
+}
+
+// The arms Select reports besides the index of a ready case.
+const (
+	SelectTimeout = -1
+	SelectDefault = -2
+)
+
+// CheckSend raises an error unless a select case may send value to stream.
+func CheckSend(stream *Stream, value Value, pos Position) {
+	if !stream.ElemType.Accepts(value) {
+		panic(NewRuntimeError(pos, "Cannot send %s to stream of %s", TypeOf(value).Name, stream.ElemType))
+	}
+}
+
+// SelectTimeoutDuration converts the seconds of a select timeout arm.
+// source is the timeout expression.
+func SelectTimeoutDuration(seconds Value, source string, pos Position) time.Duration {
+	d, ok := duration(seconds)
+	if !ok {
+		panic(NewRuntimeError(pos, "Expected int or float seconds for select timeout: %s", source))
+	}
+	return d
+}
+
+// duration converts int or float seconds, as sleep and select timeouts
+// take them.
+func duration(seconds Value) (time.Duration, bool) {
+	switch s := seconds.(type) {
+	case Int:
+		return time.Duration(s) * time.Second, true
+	case Float:
+		return time.Duration(float64(s) * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// Select waits until one of the cases can proceed, timeout fires or, with
+// hasDefault, right away when nothing is ready. A nil timeout never fires.
+// It returns the index of the chosen case or one of SelectTimeout and
+// SelectDefault, and the value received by a receive case. thread gives up
+// its processor while it waits.
+func Select(thread *Thread, cases []SelectCase, timeout *time.Duration, hasDefault bool, pos Position) (int, Value) {
+	if !thread.Racing() {
+		return runSelect(thread, cases, timeout, hasDefault, pos)
+	}
+	// which send case proceeds is only known afterwards, so they all
+	// release before.
+	for _, c := range cases {
+		if c.Send {
+			c.Stream.sync.release(thread)
+		}
+	}
+	chosen, value := runSelect(thread, cases, timeout, hasDefault, pos)
+	if chosen >= 0 && !cases[chosen].Send {
+		cases[chosen].Stream.sync.acquire(thread)
+	}
+	return chosen, value
+}
+
+func runSelect(thread *Thread, cases []SelectCase, timeout *time.Duration, hasDefault bool, pos Position) (int, Value) {
+	if thread.deterministic() {
+		defer RethrowAt(pos)
+		var d time.Duration
+		if timeout != nil {
+			d = *timeout
+		}
+		return thread.sched.selectCases(thread, cases, d, timeout != nil, hasDefault)
+	}
+
+	selectCases := make([]reflect.SelectCase, 0, len(cases)+2)
+	for i := range cases {
+		c := &cases[i]
+		if c.Send {
+			selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c.Stream.ch), Send: reflect.ValueOf(&c.Value).Elem()})
+		} else {
+			selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.Stream.ch)})
+		}
+	}
+
+	timeoutIndex, defaultIndex := -1, -1
+	if timeout != nil {
+		timeoutIndex = len(selectCases)
+		selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(time.After(*timeout))})
+	}
+	if hasDefault {
+		defaultIndex = len(selectCases)
+		selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+
+	var chosen int
+	var received reflect.Value
+	if hasDefault {
+		chosen, received = selectStreams(selectCases, pos)
+	} else {
+		// only wait without a processor when no case is ready yet.
+		ready := append(selectCases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		chosen, received = selectStreams(ready, pos)
+		if chosen == len(selectCases) {
+			reason := waitSelect
+			if timeout != nil {
+				reason = waitTimeout
+			}
+			thread.block(reason, func() { chosen, received = selectStreams(selectCases, pos) })
+		}
+	}
+	switch chosen {
+	case timeoutIndex:
+		return SelectTimeout, nil
+	case defaultIndex:
+		return SelectDefault, nil
+	}
+	var value Value
+	if received.IsValid() && !received.IsNil() {
+		value = received.Interface().(Value)
+	}
+	return chosen, value
+}
+
+func selectStreams(cases []reflect.SelectCase, pos Position) (chosen int, received reflect.Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(NewRuntimeError(pos, "Send on closed stream"))
+		}
+	}()
+	chosen, received, _ = reflect.Select(cases)
+	return chosen, received
+}
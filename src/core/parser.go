@@ -11,11 +11,67 @@ type Parser struct {
 }
 
 func isAssignmentOperator(t TokenType) bool {
-	return t == ASSIGN || t == ADD_ASSIGN || t == SUB_ASSIGN || t == MUL_ASSIGN || t == DIV_ASSIGN
+	return t == ASSIGN || t == ADD_ASSIGN || t == SUB_ASSIGN || t == MUL_ASSIGN || t == DIV_ASSIGN || t == MOD_ASSIGN
 }
 
 func (p *Parser) isTypeAssignment(offset int) bool {
-	return p.lookahead(offset).Type == COLON && p.lookahead(offset+1).Type == IDENTIFIER && isAssignmentOperator(p.lookahead(offset+2).Type)
+	if p.lookahead(offset).Type != COLON {
+		return false
+	}
+	length := p.typeLength(offset + 1)
+	return length > 0 && isAssignmentOperator(p.lookahead(offset+1+length).Type)
+}
+
+// typeLength returns how many tokens the type expression starting at offset
+// spans, or 0 if there is no type expression there.
+func (p *Parser) typeLength(offset int) int {
+	start := offset
+	switch p.lookahead(offset).Type {
+	case FUNC:
+		offset++
+		if p.lookahead(offset).Type != LPAREN {
+			return 0
+		}
+		offset++
+		for p.lookahead(offset).Type != RPAREN {
+			length := p.typeLength(offset)
+			if length == 0 {
+				return 0
+			}
+			offset += length
+			if p.lookahead(offset).Type == COMMA {
+				offset++
+			}
+		}
+		offset++
+		if p.lookahead(offset).Type != ARROW {
+			return 0
+		}
+		length := p.typeLength(offset + 1)
+		if length == 0 {
+			return 0
+		}
+		return offset + 1 + length - start
+	case IDENTIFIER:
+		offset++
+		if p.lookahead(offset).Type != LBRACKET {
+			return 1
+		}
+		offset++
+		for p.lookahead(offset).Type != RBRACKET {
+			length := p.typeLength(offset)
+			if length == 0 {
+				return 0
+			}
+			offset += length
+			if p.lookahead(offset).Type == COMMA {
+				offset++
+			}
+		}
+		return offset + 1 - start
+	default:
+		return 0
+	}
 }
 
 func (p *Parser) consume(t TokenType) Token {
@@ -39,18 +95,24 @@ func (p *Parser) lookahead(n int) Token {
 
 func (p *Parser) parseIdentifier() *IdentifierNode {
 	token := p.consume(IDENTIFIER)
-	return &IdentifierNode{Name: token.Value}
+	return &IdentifierNode{Name: token.Value, Pos: token.Pos()}
 }
 
 func (p *Parser) parseFloat() *FloatNode {
 	token := p.consume(FLOAT)
-	value, _ := strconv.ParseFloat(token.Value, 64)
+	value, err := strconv.ParseFloat(token.Value, 64)
+	if err != nil {
+		panic(fmt.Sprintf("Float literal out of range: %s at [%d:%d]", token.Value, token.Row, token.Col))
+	}
 	return &FloatNode{Value: value}
 }
 
 func (p *Parser) parseInt() *IntNode {
 	token := p.consume(INT)
-	value, _ := strconv.Atoi(token.Value)
+	value, err := strconv.Atoi(token.Value)
+	if err != nil {
+		panic(fmt.Sprintf("Integer literal out of range: %s at [%d:%d]", token.Value, token.Row, token.Col))
+	}
 	return &IntNode{Value: value}
 }
 
@@ -59,36 +121,65 @@ func (p *Parser) parseString() *StringNode {
 	return &StringNode{Value: token.Value}
 }
 
+func (p *Parser) parseType() *TypeNode {
+	token := p.current()
+	if token.Type == FUNC {
+		p.consume(FUNC)
+		p.consume(LPAREN)
+		var params []*TypeNode
+		if p.current().Type != RPAREN {
+			params = append(params, p.parseType())
+			for p.current().Type == COMMA {
+				p.consume(COMMA)
+				params = append(params, p.parseType())
+			}
+		}
+		p.consume(RPAREN)
+		p.consume(ARROW)
+		return &TypeNode{Name: "func", Params: params, ReturnType: p.parseType(), Pos: token.Pos()}
+	}
+
+	// nil is a literal, and also the type of functions returning nothing
+	if token.Type == NIL {
+		p.pos++
+		return &TypeNode{Name: "nil", Pos: token.Pos()}
+	}
+
+	name := p.consume(IDENTIFIER)
+	node := &TypeNode{Name: name.Value, Pos: name.Pos()}
+	if p.current().Type == LBRACKET {
+		p.consume(LBRACKET)
+		node.Params = append(node.Params, p.parseType())
+		for p.current().Type == COMMA {
+			p.consume(COMMA)
+			node.Params = append(node.Params, p.parseType())
+		}
+		p.consume(RBRACKET)
+	}
+	return node
+}
+
 func (p *Parser) parseParameter() *ParameterNode {
 	identifier := p.parseIdentifier()
 	p.consume(COLON)
-	typeToken := p.consume(IDENTIFIER)
-	return &ParameterNode{Name: identifier.Name, Type: typeToken.Value}
+	return &ParameterNode{Name: identifier.Name, Type: p.parseType()}
 }
 
 func (p *Parser) parseFunctionCall() *FunctionCallNode {
+	funcToken := p.current()
 	funcName := p.parseIdentifier().Name
-	p.consume(LPAREN)
-	var args []Node
-	if p.current().Type != RPAREN {
-		args = append(args, p.parseExpression())
-		for p.current().Type == COMMA {
-			p.consume(COMMA)
-			args = append(args, p.parseExpression())
-		}
-	}
-	p.consume(RPAREN)
-	return &FunctionCallNode{FunctionName: funcName, Arguments: args}
+	args := p.parseArguments()
+	return &FunctionCallNode{FunctionName: funcName, Arguments: args, Pos: funcToken.Pos()}
 }
 
 func (p *Parser) parseAssignment() *AssignmentNode {
+	varToken := p.current()
 	varName := p.parseIdentifier().Name
-	var varType string
+	var varType *TypeNode
 
 	if p.current().Type == COLON {
 		p.consume(COLON)
-		typeToken := p.consume(IDENTIFIER)
-		varType = typeToken.Value
+		varType = p.parseType()
 	}
 
 	var value Node
@@ -96,50 +187,232 @@ func (p *Parser) parseAssignment() *AssignmentNode {
 	case ASSIGN:
 		p.consume(ASSIGN)
 		value = p.parseExpression()
-	case ADD_ASSIGN, SUB_ASSIGN, MUL_ASSIGN, DIV_ASSIGN:
+	case ADD_ASSIGN, SUB_ASSIGN, MUL_ASSIGN, DIV_ASSIGN, MOD_ASSIGN:
 		opToken := p.current()
 		p.pos++
-		if varType != "" {
+		if varType != nil {
 			panic(fmt.Sprintf("Cannot specify type with compound assignment at [%d:%d]", opToken.Row, opToken.Col))
 		}
 		value = &BinOpNode{
-			Left:  &IdentifierNode{Name: varName},
+			Left:  &IdentifierNode{Name: varName, Pos: varToken.Pos()},
 			Op:    opToken.Value[:1],
 			Right: p.parseExpression(),
+			Pos:   opToken.Pos(),
 		}
 	default:
 		panic(fmt.Sprintf("Unexpected token %s for assignment at [%d:%d]", p.current().Type, p.current().Row, p.current().Col))
 	}
-	return &AssignmentNode{VarName: varName, Type: varType, Value: value}
+	return &AssignmentNode{VarName: varName, Type: varType, Value: value, Pos: varToken.Pos()}
 }
 
 func (p *Parser) parseForLoop() *ForLoopNode {
-	p.consume(FOR)
+	forToken := p.consume(FOR)
 	variable := p.parseIdentifier().Name
+	var valueVariable string
+	if p.current().Type == COMMA {
+		p.consume(COMMA)
+		valueVariable = p.parseIdentifier().Name
+	}
 	p.consume(IN)
-	p.consume(RANGE)
-	p.consume(LPAREN)
-	rng := p.parseExpression()
-	p.consume(RPAREN)
+	var rng, iterable Node
+	if p.current().Type == RANGE {
+		if valueVariable != "" {
+			panic(fmt.Sprintf("range loop takes a single variable at [%d:%d]", forToken.Row, forToken.Col))
+		}
+		p.consume(RANGE)
+		p.consume(LPAREN)
+		rng = p.parseExpression()
+		p.consume(RPAREN)
+	} else {
+		iterable = p.parseExpression()
+	}
+	body := p.parseBlock()
+	return &ForLoopNode{Variable: variable, ValueVariable: valueVariable, Range: rng, Iterable: iterable, Body: body, Pos: forToken.Pos()}
+}
+
+func (p *Parser) parseIf() *IfNode {
+	ifToken := p.consume(IF)
+	condition := p.parseExpression()
+	then := p.parseBlock()
+
+	var elseBody []Node
+	if p.current().Type == ELSE {
+		p.consume(ELSE)
+		if p.current().Type == IF {
+			elseBody = []Node{p.parseIf()}
+		} else {
+			elseBody = p.parseBlock()
+		}
+	}
+	return &IfNode{Condition: condition, Then: then, Else: elseBody, Pos: ifToken.Pos()}
+}
+
+func (p *Parser) parseStatement() Node {
+	switch p.current().Type {
+	case RETURN:
+		return p.parseReturn()
+	case FUNC:
+		// nested named declarations; func(...) stays a literal expression
+		if p.lookahead(1).Type == IDENTIFIER {
+			return p.parseFunction()
+		}
+	case CLASS:
+		return p.parseClass()
+	}
+	return p.parseExpression()
+}
+
+func (p *Parser) parseBlock() []Node {
 	p.consume(LBRACE)
+	var body []Node
+	for p.current().Type != RBRACE && p.current().Type != EOF {
+		body = append(body, p.parseStatement())
+	}
+	p.consume(RBRACE)
+	return body
+}
+
+func (p *Parser) parseSpawn() *SpawnNode {
+	upToken := p.consume(UP)
+	call, ok := p.parsePrimary().(*FunctionCallNode)
+	if !ok {
+		panic(fmt.Sprintf("Expected function call after up at [%d:%d]", upToken.Row, upToken.Col))
+	}
+	return &SpawnNode{Call: call, Pos: upToken.Pos()}
+}
 
+func (p *Parser) parseSelectArmBody() []Node {
 	var body []Node
+	for {
+		switch p.current().Type {
+		case CASE, DEFAULT, TIMEOUT, RBRACE, EOF:
+			return body
+		}
+		body = append(body, p.parseStatement())
+	}
+}
+
+func (p *Parser) parseSelect() *SelectNode {
+	selectToken := p.consume(SELECT)
+	p.consume(LBRACE)
+
+	node := &SelectNode{Pos: selectToken.Pos()}
+	for p.current().Type != RBRACE && p.current().Type != EOF {
+		armToken := p.current()
+		switch armToken.Type {
+		case CASE:
+			p.consume(CASE)
+			comm := p.parseExpression()
+			switch c := comm.(type) {
+			case *SendNode, *ReceiveNode:
+			case *AssignmentNode:
+				if _, ok := c.Value.(*ReceiveNode); !ok {
+					panic(fmt.Sprintf("Select case must receive from a stream at [%d:%d]", armToken.Row, armToken.Col))
+				}
+			default:
+				panic(fmt.Sprintf("Select case must be a send or receive at [%d:%d]", armToken.Row, armToken.Col))
+			}
+			p.consume(COLON)
+			node.Cases = append(node.Cases, &SelectCaseNode{Comm: comm, Body: p.parseSelectArmBody()})
+		case DEFAULT:
+			if node.HasDefault {
+				panic(fmt.Sprintf("Multiple defaults in select at [%d:%d]", armToken.Row, armToken.Col))
+			}
+			p.consume(DEFAULT)
+			p.consume(COLON)
+			node.HasDefault = true
+			node.Default = p.parseSelectArmBody()
+		case TIMEOUT:
+			if node.Timeout != nil {
+				panic(fmt.Sprintf("Multiple timeouts in select at [%d:%d]", armToken.Row, armToken.Col))
+			}
+			p.consume(TIMEOUT)
+			node.Timeout = p.parseExpression()
+			p.consume(COLON)
+			node.TimeoutBody = p.parseSelectArmBody()
+		default:
+			panic(fmt.Sprintf("Unexpected token %s in select at [%d:%d]", armToken.Type, armToken.Row, armToken.Col))
+		}
+	}
+	p.consume(RBRACE)
+	return node
+}
+
+func (p *Parser) parseThrow() *ThrowNode {
+	throwToken := p.consume(THROW)
+	return &ThrowNode{Value: p.parseExpression(), Pos: throwToken.Pos()}
+}
+
+func (p *Parser) parseTry() *TryNode {
+	tryToken := p.consume(TRY)
+	body := p.parseBlock()
+	p.consume(CATCH)
+	var catchVar string
+	if p.current().Type == IDENTIFIER {
+		catchVar = p.parseIdentifier().Name
+	}
+	catch := p.parseBlock()
+	return &TryNode{Body: body, CatchVar: catchVar, Catch: catch, Pos: tryToken.Pos()}
+}
+
+func (p *Parser) parseClass() *ClassDeclarationNode {
+	classToken := p.consume(CLASS)
+	name := p.parseIdentifier()
+	p.consume(LBRACE)
+
+	class := &ClassDeclarationNode{Name: name.Name, Pos: classToken.Pos()}
 	for p.current().Type != RBRACE && p.current().Type != EOF {
-		body = append(body, p.parseExpression())
+		if p.current().Type == FUNC {
+			method := p.parseFunction()
+			if method.Receiver != nil {
+				panic(fmt.Sprintf("Methods inside class %s cannot declare a receiver at [%d:%d]", class.Name, method.Pos.Row, method.Pos.Col))
+			}
+			method.Receiver = &TypeNode{Name: class.Name, Pos: method.Pos}
+			class.Methods = append(class.Methods, method)
+			continue
+		}
+
+		fieldToken := p.current()
+		field := &FieldNode{Name: p.parseIdentifier().Name, Pos: fieldToken.Pos()}
+		p.consume(COLON)
+		field.Type = p.parseType()
+		if p.current().Type == ASSIGN {
+			p.consume(ASSIGN)
+			field.Default = p.parseExpression()
+		}
+		class.Fields = append(class.Fields, field)
 	}
 	p.consume(RBRACE)
-	return &ForLoopNode{Variable: variable, Range: rng, Body: body}
+	return class
 }
 
 func (p *Parser) parseFunction() *FuncDeclarationNode {
-	p.consume(FUNC)
+	funcToken := p.consume(FUNC)
 	var funcName *IdentifierNode
+	var receiver *TypeNode
     if p.current().Type == MAIN {
         funcName = &IdentifierNode{Name: "main"}
         p.consume(MAIN)
+    } else if p.lookahead(1).Type == DOT {
+        // func Type.name(...) declares a method of Type
+        receiver = p.parseType()
+        p.consume(DOT)
+        funcName = p.parseIdentifier()
     } else {
         funcName = p.parseIdentifier()
     }
+	function := p.parseFunctionSignatureAndBody(funcToken, funcName.Name)
+	function.Receiver = receiver
+	return function
+}
+
+// parseFunctionLiteral parses an anonymous function used as a value.
+func (p *Parser) parseFunctionLiteral() *FuncDeclarationNode {
+	funcToken := p.consume(FUNC)
+	return p.parseFunctionSignatureAndBody(funcToken, "")
+}
+
+func (p *Parser) parseFunctionSignatureAndBody(funcToken Token, name string) *FuncDeclarationNode {
 	p.consume(LPAREN)
 	var parameters []*ParameterNode
 	if p.current().Type != RPAREN {
@@ -151,41 +424,76 @@ func (p *Parser) parseFunction() *FuncDeclarationNode {
 	}
 	p.consume(RPAREN)
 	p.consume(ARROW)
-	returnType := p.parseIdentifier()
-	p.consume(LBRACE)
-	var body []Node
-	for p.current().Type != RBRACE && p.current().Type != EOF {
-		if p.current().Type == RETURN {
-			body = append(body, p.parseReturn())
-		} else {
-			body = append(body, p.parseExpression())
-		}
-	}
-	p.consume(RBRACE)
-	return &FuncDeclarationNode{Name: funcName.Name, Parameters: parameters, ReturnType: returnType.Name, Body: body}
+	returnType := p.parseType()
+	body := p.parseBlock()
+	return &FuncDeclarationNode{Name: name, Parameters: parameters, ReturnType: returnType, Body: body, Pos: funcToken.Pos()}
 }
 
 func (p *Parser) parseReturn() *ReturnNode {
-	p.consume(RETURN)
+	returnToken := p.consume(RETURN)
+
+	// a bare return ends the block or stands alone on its line.
+	next := p.current()
+	switch next.Type {
+	case RBRACE, EOF, CASE, DEFAULT, TIMEOUT:
+		return &ReturnNode{Pos: returnToken.Pos()}
+	}
+	if next.Row > returnToken.Row {
+		return &ReturnNode{Pos: returnToken.Pos()}
+	}
+
 	value := p.parseExpression()
-	return &ReturnNode{Value: value}
+	return &ReturnNode{Value: value, Pos: returnToken.Pos()}
+}
+
+// parseTargetAssignment parses the assignment to a field or an element
+// after its target has been parsed as an expression, e.g. this.x = x,
+// p.count += 1 or xs[0] = 5.
+func (p *Parser) parseTargetAssignment(target Node) Node {
+	opToken := p.current()
+	p.pos++
+	value := p.parseExpression()
+	// a compound assignment keeps its operator, so the target is only
+	// evaluated once
+	op := ""
+	if opToken.Type != ASSIGN {
+		op = opToken.Value[:1]
+	}
+
+	switch t := target.(type) {
+	case *FieldAccessNode:
+		return &FieldAssignmentNode{Object: t.Object, Field: t.Field, Op: op, Value: value, Pos: t.Pos}
+	case *IndexNode:
+		return &IndexAssignmentNode{Object: t.Object, Index: t.Index, Op: op, Value: value, Pos: t.Pos}
+	default:
+		panic(fmt.Sprintf("Cannot assign to %s at [%d:%d]", target.String(), opToken.Row, opToken.Col))
+	}
 }
 
 func (p *Parser) parseExpression() Node {
 	left := p.parsePrimary()
+	if isAssignmentOperator(p.current().Type) {
+		return p.parseTargetAssignment(left)
+	}
 
 	for precedence := getPrecedence(p.current().Type); precedence > 0; precedence = getPrecedence(p.current().Type) {
 		left = p.parseBinOp(left, precedence)
 	}
 
+	// stream <- value; a <- starting the next line is a receive statement
+	if p.current().Type == LARROW && p.current().Row == p.tokens[p.pos-1].Row {
+		arrowToken := p.consume(LARROW)
+		return &SendNode{Stream: left, Value: p.parseExpression(), Pos: arrowToken.Pos()}
+	}
+
 	return left
 }
 
 func (p *Parser) parseTypedDeclaration() *AssignmentNode {
+	varToken := p.current()
 	varName := p.parseIdentifier().Name
 	p.consume(COLON)
-	typeToken := p.consume(IDENTIFIER)
-	varType := typeToken.Value
+	varType := p.parseType()
 
 	var value Node
 	if p.current().Type == ASSIGN {
@@ -193,15 +501,110 @@ func (p *Parser) parseTypedDeclaration() *AssignmentNode {
 		value = p.parseExpression()
 	}
 
-	return &AssignmentNode{VarName: varName, Type: varType, Value: value}
+	return &AssignmentNode{VarName: varName, Type: varType, Value: value, Pos: varToken.Pos()}
 }
 
+func (p *Parser) parseArguments() []Node {
+	p.consume(LPAREN)
+	var args []Node
+	if p.current().Type != RPAREN {
+		args = append(args, p.parseExpression())
+		for p.current().Type == COMMA {
+			p.consume(COMMA)
+			args = append(args, p.parseExpression())
+		}
+	}
+	p.consume(RPAREN)
+	return args
+}
+
+// parsePrimary parses an operand followed by any number of calls, method
+// calls, field accesses and indexes, e.g. counter()(), 1.sum(3), xs[1:] or
+// (func(x: int) -> int { return x })(1). A call or index has to start on
+// the same line as the end of its operand.
 func (p *Parser) parsePrimary() Node {
+	node := p.parseOperand()
+	for {
+		switch {
+		case p.current().Type == LPAREN && p.current().Row == p.tokens[p.pos-1].Row:
+			callToken := p.current()
+			node = &FunctionCallNode{Callee: node, Arguments: p.parseArguments(), Pos: callToken.Pos()}
+		case p.current().Type == LBRACKET && p.current().Row == p.tokens[p.pos-1].Row:
+			node = p.parseIndexOrSlice(node)
+		case p.current().Type == DOT:
+			p.consume(DOT)
+			member := p.consume(IDENTIFIER)
+			if p.current().Type == LPAREN {
+				node = &MethodCallNode{Receiver: node, Method: member.Value, Arguments: p.parseArguments(), Pos: member.Pos()}
+			} else {
+				node = &FieldAccessNode{Object: node, Field: member.Value, Pos: member.Pos()}
+			}
+		default:
+			return node
+		}
+	}
+}
+
+func (p *Parser) parseIndexOrSlice(object Node) Node {
+	bracketToken := p.consume(LBRACKET)
+	var low Node
+	if p.current().Type != COLON {
+		low = p.parseExpression()
+		if p.current().Type == RBRACKET {
+			p.consume(RBRACKET)
+			return &IndexNode{Object: object, Index: low, Pos: bracketToken.Pos()}
+		}
+	}
+	p.consume(COLON)
+	var high Node
+	if p.current().Type != RBRACKET {
+		high = p.parseExpression()
+	}
+	p.consume(RBRACKET)
+	return &SliceNode{Object: object, Low: low, High: high, Pos: bracketToken.Pos()}
+}
+
+// parseMap parses a map literal. A brace in operand position is always a
+// map, because blocks only follow statements such as if, for or func.
+func (p *Parser) parseMap() *MapNode {
+	braceToken := p.consume(LBRACE)
+	node := &MapNode{Pos: braceToken.Pos()}
+	for p.current().Type != RBRACE {
+		node.Keys = append(node.Keys, p.parseExpression())
+		p.consume(COLON)
+		node.Values = append(node.Values, p.parseExpression())
+		if p.current().Type != COMMA {
+			break
+		}
+		p.consume(COMMA)
+	}
+	p.consume(RBRACE)
+	return node
+}
+
+func (p *Parser) parseList() *ListNode {
+	bracketToken := p.consume(LBRACKET)
+	var elements []Node
+	if p.current().Type != RBRACKET {
+		elements = append(elements, p.parseExpression())
+		for p.current().Type == COMMA {
+			p.consume(COMMA)
+			if p.current().Type == RBRACKET {
+				break
+			}
+			elements = append(elements, p.parseExpression())
+		}
+	}
+	p.consume(RBRACKET)
+	return &ListNode{Elements: elements, Pos: bracketToken.Pos()}
+}
+
+func (p *Parser) parseOperand() Node {
 	switch p.current().Type {
 	case IDENTIFIER:
 		if p.lookahead(1).Type == LPAREN {
 			return p.parseFunctionCall()
-		} else if isAssignmentOperator(p.lookahead(1).Type) || p.lookahead(1).Type == COLON {
+		} else if isAssignmentOperator(p.lookahead(1).Type) || p.isTypeAssignment(1) {
 			return p.parseAssignment()
 		}
 		return p.parseIdentifier()
@@ -211,6 +614,21 @@ func (p *Parser) parsePrimary() Node {
 		return p.parseFloat()
 	case STRING:
 		return p.parseString()
+	case LBRACKET:
+		return p.parseList()
+	case LBRACE:
+		return p.parseMap()
+	case TRUE, FALSE:
+		token := p.current()
+		p.pos++
+		return &BoolNode{Value: token.Type == TRUE}
+	case NIL:
+		p.pos++
+		return &NilNode{}
+	case NOT, SUB:
+		token := p.current()
+		p.pos++
+		return &UnaryOpNode{Op: token.Value, Operand: p.parsePrimary(), Pos: token.Pos()}
 	case LPAREN:
 		p.consume(LPAREN)
 		expr := p.parseExpression()
@@ -218,6 +636,24 @@ func (p *Parser) parsePrimary() Node {
 		return expr
 	case FOR:
 		return p.parseForLoop()
+	case IF:
+		return p.parseIf()
+	case THROW:
+		return p.parseThrow()
+	case TRY:
+		return p.parseTry()
+	case UP:
+		return p.parseSpawn()
+	case FUNC:
+		if p.lookahead(1).Type == LPAREN {
+			return p.parseFunctionLiteral()
+		}
+		panic(fmt.Sprintf("Unexpected token %s at [%d:%d]", p.current().Type, p.current().Row, p.current().Col))
+	case SELECT:
+		return p.parseSelect()
+	case LARROW:
+		arrowToken := p.consume(LARROW)
+		return &ReceiveNode{Stream: p.parsePrimary(), Pos: arrowToken.Pos()}
 	default:
 		panic(fmt.Sprintf("Unexpected token %s at [%d:%d]", p.current().Type, p.current().Row, p.current().Col))
 	}
@@ -240,16 +676,22 @@ func (p *Parser) parseBinOp(left Node, minPrecedence int) Node {
 			right = p.parseBinOp(right, precedence+1)
 		}
 
-		left = &BinOpNode{Left: left, Op: opToken.Value, Right: right}
+		left = &BinOpNode{Left: left, Op: opToken.Value, Right: right, Pos: opToken.Pos()}
 	}
 	return left
 }
 
 func getPrecedence(tokenType TokenType) int {
 	switch tokenType {
-	case MUL, DIV:
-		return 2
+	case MUL, DIV, MOD:
+		return 5
 	case ADD, SUB:
+		return 4
+	case LT, GT, LTE, GTE, EQ, NOT_EQ:
+		return 3
+	case AND:
+		return 2
+	case OR:
 		return 1
 	default:
 		return 0
@@ -257,12 +699,17 @@ func getPrecedence(tokenType TokenType) int {
 }
 
 func (p *Parser) parseProgram() *ProgramNode {
+	var classes []*ClassDeclarationNode
 	var functions []*FuncDeclarationNode
 	for p.current().Type != EOF {
+		if p.current().Type == CLASS {
+			classes = append(classes, p.parseClass())
+			continue
+		}
 		function := p.parseFunction()
 		functions = append(functions, function)
 	}
-	return &ProgramNode{Functions: functions}
+	return &ProgramNode{Classes: classes, Functions: functions}
 }
 
 func NewParser(tokens []Token) *Parser {
@@ -0,0 +1,185 @@
+package up
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// The race detector reports two accesses to a variable from different
+// threads, at least one of them a write, when neither happened before
+// the other. Happens-before is tracked with vector clocks: spawning a
+// thread, sending to a stream or closing it, and unlocking a mutex
+// release the clock of the thread, and the thread that starts, receives
+// or locks acquires it. Sleeping orders nothing.
+
+// vectorClock holds, for each thread ID, the latest clock of that thread
+// the owner has synchronized with.
+type vectorClock []uint64
+
+func (c vectorClock) get(id int64) uint64 {
+	if id < int64(len(c)) {
+		return c[id]
+	}
+	return 0
+}
+
+func (c *vectorClock) set(id int64, clock uint64) {
+	for int64(len(*c)) <= id {
+		*c = append(*c, 0)
+	}
+	(*c)[id] = clock
+}
+
+func (c *vectorClock) join(other vectorClock) {
+	for id, clock := range other {
+		if clock > c.get(int64(id)) {
+			c.set(int64(id), clock)
+		}
+	}
+}
+
+func (c vectorClock) copy() vectorClock {
+	return append(vectorClock(nil), c...)
+}
+
+// syncClock is the clock released into a stream or mutex.
+type syncClock struct {
+	mu    sync.Mutex
+	clock vectorClock
+}
+
+// release publishes everything t did so far to the threads acquiring c
+// later, and starts a new clock for what t does next.
+func (c *syncClock) release(t *Thread) {
+	c.mu.Lock()
+	c.clock.join(t.clock)
+	c.mu.Unlock()
+	t.clock.set(t.ID, t.clock.get(t.ID)+1)
+}
+
+func (c *syncClock) acquire(t *Thread) {
+	c.mu.Lock()
+	t.clock.join(c.clock)
+	c.mu.Unlock()
+}
+
+// SetRaceDetection turns the race detector on. It must be called before
+// the first thread runs.
+func (s *Scheduler) SetRaceDetection() {
+	s.race = true
+	s.reported = map[[2]Position]bool{}
+}
+
+// Racing reports whether the accesses of t are checked for races.
+func (t *Thread) Racing() bool {
+	return t != nil && t.sched.race
+}
+
+// Races returns the number of races reported.
+func (s *Scheduler) Races() int {
+	s.raceMu.Lock()
+	defer s.raceMu.Unlock()
+	return len(s.reported)
+}
+
+// access is a read or write of a variable by a thread at its clock.
+type access struct {
+	thread *Thread
+	clock  uint64
+	pos    Position
+}
+
+// seenBy reports whether the access happened before what t does now.
+func (a access) seenBy(t *Thread) bool {
+	return a.thread == t || a.clock <= t.clock.get(a.thread.ID)
+}
+
+// Shadow records the last write of a variable and the reads since then,
+// one per thread, for the race detector.
+type Shadow struct {
+	mu    sync.Mutex
+	write access
+	reads []access
+}
+
+// Read checks a read of the variable name at pos by t.
+func (t *Thread) Read(s *Shadow, name string, pos Position) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.write.thread != nil && !s.write.seenBy(t) {
+		t.sched.reportRace(name, "read", t, pos, "write", s.write)
+	}
+	current := access{thread: t, clock: t.clock.get(t.ID), pos: pos}
+	for i, read := range s.reads {
+		if read.thread == t {
+			s.reads[i] = current
+			return
+		}
+	}
+	s.reads = append(s.reads, current)
+}
+
+// Write checks a write of the variable name at pos by t.
+func (t *Thread) Write(s *Shadow, name string, pos Position) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.write.thread != nil && !s.write.seenBy(t) {
+		t.sched.reportRace(name, "write", t, pos, "write", s.write)
+	}
+	for _, read := range s.reads {
+		if !read.seenBy(t) {
+			t.sched.reportRace(name, "write", t, pos, "read", read)
+		}
+	}
+	s.reads = s.reads[:0]
+	s.write = access{thread: t, clock: t.clock.get(t.ID), pos: pos}
+}
+
+// reportRace prints a race on stderr, once for each pair of positions.
+func (s *Scheduler) reportRace(name string, kind string, t *Thread, pos Position, previousKind string, previous access) {
+	s.raceMu.Lock()
+	defer s.raceMu.Unlock()
+	key := [2]Position{pos, previous.pos}
+	if s.reported[key] {
+		return
+	}
+	s.reported[key] = true
+	fmt.Fprintf(os.Stderr, "Data race on variable %s:\n", name)
+	fmt.Fprintf(os.Stderr, "    %s at %s by thread #%d %s\n", kind, pos, t.ID, t.Name)
+	fmt.Fprintf(os.Stderr, "    previous %s at %s by thread #%d %s\n", previousKind, previous.pos, previous.thread.ID, previous.thread.Name)
+}
+
+// newShadow returns the shadow of a variable defined in e, or nil when
+// races are not checked. Globals are only set before any thread is
+// spawned and are not tracked.
+func (e *Environment) newShadow() *Shadow {
+	if e.outer == nil || !e.thread.Racing() {
+		return nil
+	}
+	return &Shadow{}
+}
+
+// shadowOf returns the shadow of the variable name in the scope defining
+// it.
+func (e *Environment) shadowOf(name string) *Shadow {
+	for scope := e; scope.outer != nil; scope = scope.outer {
+		if b := scope.binding(name); b != nil {
+			return b.shadow
+		}
+	}
+	return nil
+}
+
+// raceRead and raceWrite check an access to a variable of the scope e.
+func (e *Environment) raceRead(name string, pos Position) {
+	if shadow := e.shadowOf(name); shadow != nil {
+		e.thread.Read(shadow, name, pos)
+	}
+}
+
+func (e *Environment) raceWrite(name string, pos Position) {
+	if shadow := e.shadowOf(name); shadow != nil {
+		e.thread.Write(shadow, name, pos)
+	}
+}
@@ -2,135 +2,617 @@ package up
 
 import (
 	"fmt"
+	"time"
 )
 
 type Options struct {
 	Debug bool
 	Compile bool
+	// Check runs the static checker before execution; the program only
+	// runs when no errors were found.
+	Check bool
+	// VM compiles the program to bytecode and runs it on the virtual
+	// machine instead of walking the tree.
+	VM bool
+	// Procs is the number of processors running up threads, one per CPU
+	// when zero.
+	Procs int
+	// Stats prints the scheduler counters when the program ends.
+	Stats bool
+	// Deterministic runs threads one at a time in an order drawn from
+	// Seed, so a seed always replays the same interleaving. A random seed
+	// is picked when Seed is zero.
+	Deterministic bool
+	Seed          int64
+	// Race reports variables accessed by several threads without
+	// synchronization.
+	Race bool
 }
 
-func ExecuteNode(node Node, env *Environment) interface{} {
+func ExecuteNode(node Node, env *Environment) Value {
 	switch n := node.(type) {
 	case *ProgramNode:
-		var result interface{}
+		var result Value
+		for _, class := range n.Classes {
+			ExecuteNode(class, env)
+		}
 		for _, function := range n.Functions {
-			env.Set(function.Name, function)
+			ExecuteNode(function, env)
 		}
 
 		if mainFunc, ok := env.Get("main"); ok {
-			if mainFuncObj, isFunc := mainFunc.(*FuncDeclarationNode); isFunc {
-				newEnv := NewEnvironment()
-				newEnv.outer = env
-				for _, stmt := range mainFuncObj.Body {
-					result = ExecuteNode(stmt, newEnv)
-				}
+			if mainFuncObj, isFunc := mainFunc.(*Function); isFunc {
+				result = callFunction(env.thread, mainFuncObj, nil, mainFuncObj.Decl.Pos)
 			}
 		}
+
+		// the program ends when main and every thread spawned with up are done.
+		env.Threads().Wait()
 		return result
 	case *FuncDeclarationNode:
-		return n
+		// evaluating a declaration or literal captures the current scope,
+		// which other threads may run from now on.
+		env.share()
+		function := &Function{Decl: n, Env: env}
+		if n.Receiver != nil {
+			env.Methods().Define(n.Receiver.Name, n.Name, function)
+			return nil
+		}
+		if n.Name != "" {
+			setVariable(env, n.Name, function, n.Pos)
+		}
+		return function
 	case *FunctionCallNode:
-		if function, ok := env.Get(n.FunctionName); ok {
-			if funcObj, isUserDefined := function.(*FuncDeclarationNode); isUserDefined {
-				newEnv := NewEnvironment()
-				newEnv.outer = env
-
-				if len(n.Arguments) != len(funcObj.Parameters) {
-					panic(fmt.Sprintf("Expected %d arguments but got %d", len(funcObj.Parameters), len(n.Arguments)))
-				}
-
-				for i, param := range funcObj.Parameters {
-					newEnv.Set(param.Name, ExecuteNode(n.Arguments[i], env))
-				}
-
-				var result interface{}
-				for _, stmt := range funcObj.Body {
-					result = ExecuteNode(stmt, newEnv)
-				}
-				return result
-			} else if fn, isBuiltIn := function.(BuiltinFunction); isBuiltIn {
-				argsVal := make([]interface{}, len(n.Arguments))
-				for i, argNode := range n.Arguments {
-					argsVal[i] = ExecuteNode(argNode, env)
-				}
-				return fn(argsVal)
-			} else {
-				panic("Function " + n.FunctionName + " is neither user-defined nor built-in!")
-			}
-		} else {
-			panic("Function " + n.FunctionName + " not found!")
+		function := evaluateCallee(n, env)
+		argsVal := make([]Value, len(n.Arguments))
+		for i, argNode := range n.Arguments {
+			argsVal[i] = ExecuteNode(argNode, env)
+		}
+		return callValue(env.thread, function, argsVal, n)
+	case *ClassDeclarationNode:
+		env.share()
+		class := NewClassType(n, env)
+		setVariable(env, n.Name, class, n.Pos)
+		for _, method := range n.Methods {
+			ExecuteNode(method, env)
+		}
+		return class
+	case *MapNode:
+		m := NewMap()
+		for i, keyNode := range n.Keys {
+			key := ExecuteNode(keyNode, env)
+			value := ExecuteNode(n.Values[i], env)
+			func() {
+				defer RethrowAt(n.Pos)
+				m.Set(key, value)
+			}()
+		}
+		return m
+	case *ListNode:
+		elements := make([]Value, len(n.Elements))
+		for i, element := range n.Elements {
+			elements[i] = ExecuteNode(element, env)
 		}
+		return NewList(elements)
+	case *IndexNode:
+		object := ExecuteNode(n.Object, env)
+		return Index(object, ExecuteNode(n.Index, env), n.Index.String(), n.Pos)
+	case *SliceNode:
+		object := ExecuteNode(n.Object, env)
+		var low, high Value
+		lowSource, highSource := "", ""
+		if n.Low != nil {
+			low, lowSource = ExecuteNode(n.Low, env), n.Low.String()
+		}
+		if n.High != nil {
+			high, highSource = ExecuteNode(n.High, env), n.High.String()
+		}
+		return Slice(object, low, high, lowSource, highSource, n.Pos)
+	case *IndexAssignmentNode:
+		object := ExecuteNode(n.Object, env)
+		index := ExecuteNode(n.Index, env)
+		var current Value
+		if n.Op != "" {
+			current = Index(object, index, n.Index.String(), n.Pos)
+		}
+		value := ExecuteNode(n.Value, env)
+		if n.Op != "" {
+			value = BinaryOp(n.Op, current, value, n.Pos)
+		}
+		SetIndex(object, index, value, n.Index.String(), n.Pos)
+		return value
+	case *FieldAccessNode:
+		return GetField(ExecuteNode(n.Object, env), n.Field, n.Object.String(), n.Pos)
+	case *FieldAssignmentNode:
+		object := ExecuteNode(n.Object, env)
+		var current Value
+		if n.Op != "" {
+			current = GetField(object, n.Field, n.Object.String(), n.Pos)
+		}
+		value := ExecuteNode(n.Value, env)
+		if n.Op != "" {
+			value = BinaryOp(n.Op, current, value, n.Pos)
+		}
+		SetField(object, n.Field, value, n.Object.String(), n.Pos)
+		return value
+	case *MethodCallNode:
+		receiver := ExecuteNode(n.Receiver, env)
+		argsVal := make([]Value, len(n.Arguments))
+		for i, argNode := range n.Arguments {
+			argsVal[i] = ExecuteNode(argNode, env)
+		}
+		return callMethod(receiver, n, argsVal, env)
 	case *AssignmentNode:
 		val := ExecuteNode(n.Value, env)
-		env.Set(n.VarName, val)
+		if n.Type != nil {
+			// a typed assignment always declares a new local, and every
+			// later assignment to it is checked against the declared type
+			CheckType(n.Type, val, env, n.Pos, "variable "+n.VarName)
+			env.Declare(n.VarName, val, n.Type)
+		} else {
+			if declared := env.DeclaredType(n.VarName); declared != nil {
+				CheckType(declared, val, env, n.Pos, "variable "+n.VarName)
+			}
+			env.Assign(n.VarName, val)
+		}
+		if env.thread.Racing() {
+			env.raceWrite(n.VarName, n.Pos)
+		}
 		return val
 	case *BinOpNode:
 		left := ExecuteNode(n.Left, env)
-		right := ExecuteNode(n.Right, env)
-		
-		// Integer operations
-		if lInt, lOk := left.(int); lOk {
-			if rInt, rOk := right.(int); rOk {
-				switch n.Op {
-				case "+":
-					return lInt + rInt
-				case "-":
-					return lInt - rInt
-				case "*":
-					return lInt * rInt
-				case "/":
-					if rInt == 0 {
-						panic("Division by zero.")
-					}
-					return lInt / rInt
-				case "%":
-					return lInt % rInt
-				default:
-					panic("Unknown operator: " + n.Op)
-				}
+
+		// logical operators only evaluate the right side when needed
+		switch n.Op {
+		case "&&":
+			if !Truthy(left) {
+				return Bool(false)
 			}
-		}
-		
-		// string operations
-		if lStr, lOk := left.(string); lOk {
-			if rStr, rOk := right.(string); rOk {
-				switch n.Op {
-				case "+":
-					return lStr + rStr
-				default:
-					panic("Invalid operation between strings: " + n.Op)
-				}
+			return Bool(Truthy(ExecuteNode(n.Right, env)))
+		case "||":
+			if Truthy(left) {
+				return Bool(true)
 			}
+			return Bool(Truthy(ExecuteNode(n.Right, env)))
 		}
-		
-		panic("Invalid operation between different data types.")
+
+		return BinaryOp(n.Op, left, ExecuteNode(n.Right, env), n.Pos)
+	case *IntBinOpNode:
+		if isStaticInt(n) {
+			return Int(executeIntOp(n, env))
+		}
+		return Bool(executeIntComparison(n, env))
+	case *IntSlotNode:
+		return Int(env.ints[n.Slot])
+	case *IntSlotAssignmentNode:
+		value := evaluateInt(n.Value, env, n.Pos, n.Name)
+		env.ints[n.Slot] = value
+		return Int(value)
+	case *UnaryOpNode:
+		return UnaryOp(n.Op, ExecuteNode(n.Operand, env), n.Pos)
+	case *IfNode:
+		if Truthy(ExecuteNode(n.Condition, env)) {
+			return executeBlock(n.Then, env)
+		}
+		return executeBlock(n.Else, env)
+	case *BoolNode:
+		return Bool(n.Value)
+	case *NilNode:
+		return nil
 	case *FloatNode:
-		return n.Value
+		return Float(n.Value)
 	case *IntNode:
-		return n.Value
+		return Int(n.Value)
+	case *StringNode:
+		return String(n.Value)
 	case *IdentifierNode:
 		if val, ok := env.Get(n.Name); ok {
+			if env.thread.Racing() {
+				env.raceRead(n.Name, n.Pos)
+			}
 			return val
 		}
-		panic("Unknown identifier: " + n.Name)
+		panic(NewRuntimeError(n.Pos, "Unknown identifier: %s", n.Name))
 	case *ForLoopNode:
+		if n.Range == nil {
+			return executeIteration(n, env)
+		}
 		rangeValue := ExecuteNode(n.Range, env)
-		rangeInt, ok := rangeValue.(int)
+		rangeInt, ok := rangeValue.(Int)
 		if !ok {
-			panic(fmt.Sprintf("Expected integer range, but got: %T", rangeValue))
+			panic(NewRuntimeError(n.Pos, "Expected integer range, but got: %s", TypeOf(rangeValue).Name))
 		}
 
-		var result interface{}
-		for i := 0; i < rangeInt; i++ {
-			env.Set(n.Variable, i)
-			for _, bodyNode := range n.Body {
-				result = ExecuteNode(bodyNode, env)
+		var result Value
+		for i := Int(0); i < rangeInt; i++ {
+			setVariable(env, n.Variable, i, n.Pos)
+			result = executeBlock(n.Body, env)
+			if _, returned := result.(*returnValue); returned {
+				return result
 			}
+			// every iteration is a preemption point.
+			env.thread.Preempt(n.Pos)
 		}
 		return result
 	case *ReturnNode:
-		return ExecuteNode(n.Value, env)
+		if n.Value == nil {
+			return &returnValue{Pos: n.Pos, Bare: true}
+		}
+		return &returnValue{Value: ExecuteNode(n.Value, env), Pos: n.Pos}
+	case *SendNode:
+		stream, ok := ExecuteNode(n.Stream, env).(*Stream)
+		if !ok {
+			panic(NewRuntimeError(n.Pos, "Cannot send to non-stream value: %s", n.Stream.String()))
+		}
+		value := ExecuteNode(n.Value, env)
+		func() {
+			defer RethrowAt(n.Pos)
+			env.thread.At(n.Pos)
+			stream.Send(env.thread, value)
+		}()
+		return nil
+	case *ReceiveNode:
+		stream, ok := ExecuteNode(n.Stream, env).(*Stream)
+		if !ok {
+			panic(NewRuntimeError(n.Pos, "Cannot receive from non-stream value: %s", n.Stream.String()))
+		}
+		env.thread.At(n.Pos)
+		value, _ := stream.Receive(env.thread)
+		return value
+	case *SelectNode:
+		return executeSelect(n, env)
+	case *ThrowNode:
+		Throw(ExecuteNode(n.Value, env), n.Pos)
+		return nil
+	case *TryNode:
+		return executeTry(n, env)
+	case *SpawnNode:
+		function := evaluateCallee(n.Call, env)
+
+		// arguments are evaluated by the spawning thread so the new thread
+		// never observes later changes of the caller's variables.
+		argsVal := make([]Value, len(n.Call.Arguments))
+		for i, argNode := range n.Call.Arguments {
+			argsVal[i] = ExecuteNode(argNode, env)
+		}
+
+		switch fn := function.(type) {
+		case *Function:
+			if len(argsVal) != len(fn.Decl.Parameters) {
+				panic(NewRuntimeError(n.Call.Pos, "Expected %d arguments but got %d", len(fn.Decl.Parameters), len(argsVal)))
+			}
+		case BuiltinFunction:
+		default:
+			panic(NewRuntimeError(n.Call.Pos, "%s is not a function", n.Call.CalleeString()))
+		}
+		env.Threads().Spawn(env.thread, n.Call.String(), func(thread *Thread) {
+			callValue(thread, function, argsVal, n.Call)
+		})
+		return nil
 	default:
 		panic("Unknown node type")
 	}
 }
+
+// returnValue is produced by a return statement and handed up through the
+// enclosing blocks and loops until the function call unwraps it.
+type returnValue struct {
+	Value Value
+	Bare  bool
+	Pos   Position
+}
+
+// Type and String let a return travel through ExecuteNode like a value; it
+// never reaches up code.
+func (r *returnValue) Type() *DataType { return TypeOf(r.Value) }
+func (r *returnValue) String() string  { return stringify(r.Value) }
+
+// executeBlock runs statements in order and stops early on a return.
+func executeBlock(body []Node, env *Environment) Value {
+	var result Value
+	for _, stmt := range body {
+		result = ExecuteNode(stmt, env)
+		if _, returned := result.(*returnValue); returned {
+			return result
+		}
+	}
+	return result
+}
+
+func executeIteration(n *ForLoopNode, env *Environment) Value {
+	var result Value
+	switch iterable := ExecuteNode(n.Iterable, env).(type) {
+	case *List:
+		// iterate over the elements the list had when the loop started.
+		for i, value := range iterable.Snapshot() {
+			if n.ValueVariable != "" {
+				setVariable(env, n.Variable, Int(i), n.Pos)
+				setVariable(env, n.ValueVariable, value, n.Pos)
+			} else {
+				setVariable(env, n.Variable, value, n.Pos)
+			}
+			result = executeBlock(n.Body, env)
+			if _, returned := result.(*returnValue); returned {
+				return result
+			}
+			env.thread.Preempt(n.Pos)
+		}
+	case *Map:
+		keys, values := iterable.Snapshot()
+		for i, key := range keys {
+			setVariable(env, n.Variable, key, n.Pos)
+			if n.ValueVariable != "" {
+				setVariable(env, n.ValueVariable, values[i], n.Pos)
+			}
+			result = executeBlock(n.Body, env)
+			if _, returned := result.(*returnValue); returned {
+				return result
+			}
+			env.thread.Preempt(n.Pos)
+		}
+	case *Stream:
+		if n.ValueVariable != "" {
+			panic(NewRuntimeError(n.Pos, "Stream iteration takes a single variable"))
+		}
+		// a stream is consumed until it is closed by the producer.
+		for {
+			env.thread.At(n.Pos)
+			value, ok := iterable.Receive(env.thread)
+			if !ok {
+				break
+			}
+			setVariable(env, n.Variable, value, n.Pos)
+			result = executeBlock(n.Body, env)
+			if _, returned := result.(*returnValue); returned {
+				return result
+			}
+			env.thread.Preempt(n.Pos)
+		}
+	default:
+		panic(NewRuntimeError(n.Pos, "Cannot iterate over %s", TypeOf(iterable).Name))
+	}
+	return result
+}
+
+func executeSelect(n *SelectNode, env *Environment) Value {
+	// all streams and sent values are evaluated once, before waiting.
+	cases := make([]SelectCase, len(n.Cases))
+	for i, c := range n.Cases {
+		var streamNode Node
+		switch comm := c.Comm.(type) {
+		case *SendNode:
+			streamNode = comm.Stream
+		case *ReceiveNode:
+			streamNode = comm.Stream
+		case *AssignmentNode:
+			streamNode = comm.Value.(*ReceiveNode).Stream
+		}
+
+		stream, ok := ExecuteNode(streamNode, env).(*Stream)
+		if !ok {
+			panic(NewRuntimeError(n.Pos, "Cannot select on non-stream value: %s", streamNode.String()))
+		}
+		cases[i].Stream = stream
+		if send, ok := c.Comm.(*SendNode); ok {
+			cases[i].Send = true
+			cases[i].Value = ExecuteNode(send.Value, env)
+			CheckSend(stream, cases[i].Value, n.Pos)
+		}
+	}
+
+	var timeout *time.Duration
+	if n.Timeout != nil {
+		d := SelectTimeoutDuration(ExecuteNode(n.Timeout, env), n.Timeout.String(), n.Pos)
+		timeout = &d
+	}
+
+	env.thread.At(n.Pos)
+	chosen, value := Select(env.thread, cases, timeout, n.HasDefault, n.Pos)
+
+	var body []Node
+	switch chosen {
+	case SelectTimeout:
+		body = n.TimeoutBody
+	case SelectDefault:
+		body = n.Default
+	default:
+		c := n.Cases[chosen]
+		if assignment, ok := c.Comm.(*AssignmentNode); ok {
+			setVariable(env, assignment.VarName, value, n.Pos)
+		}
+		body = c.Body
+	}
+
+	return executeBlock(body, env)
+}
+
+func executeTry(n *TryNode, env *Environment) (result Value) {
+	depth := env.thread.Depth()
+	caught := func() (err *ErrorValue) {
+		defer func() {
+			if r := recover(); r != nil {
+				errValue, ok := r.(*ErrorValue)
+				if !ok {
+					panic(r)
+				}
+				env.thread.Unwind(depth)
+				err = errValue
+			}
+		}()
+		result = executeBlock(n.Body, env)
+		return nil
+	}()
+	if caught == nil {
+		return result
+	}
+
+	if n.CatchVar != "" {
+		setVariable(env, n.CatchVar, caught, n.Pos)
+	}
+	return executeBlock(n.Catch, env)
+}
+
+func evaluateCallee(n *FunctionCallNode, env *Environment) Value {
+	if n.Callee != nil {
+		return ExecuteNode(n.Callee, env)
+	}
+	function, ok := env.Get(n.FunctionName)
+	if !ok {
+		panic(NewRuntimeError(n.Pos, "Function %s not found", n.FunctionName))
+	}
+	if env.thread.Racing() {
+		env.raceRead(n.FunctionName, n.Pos)
+	}
+	return function
+}
+
+// setVariable sets a variable of the scope env, like loop and catch
+// variables, and checks the write for races. A variable declared with a
+// type keeps it, so the value is checked against it.
+func setVariable(env *Environment, name string, value Value, pos Position) {
+	if b := env.binding(name); b != nil && b.typ != nil {
+		CheckType(b.typ, value, env, pos, "variable "+name)
+	}
+	env.Set(name, value)
+	if env.thread.Racing() {
+		env.raceWrite(name, pos)
+	}
+}
+
+// callValue calls any callable value: user functions, builtins and types.
+func callValue(thread *Thread, function Value, args []Value, call *FunctionCallNode) Value {
+	switch fn := function.(type) {
+	case *Function:
+		return callFunction(thread, fn, args, call.Pos)
+	case BuiltinFunction:
+		return CallBuiltin(thread, fn, args, call.Pos)
+	case *DataType:
+		return ConstructType(thread, fn, args, call.Pos)
+	default:
+		panic(NewRuntimeError(call.Pos, "%s is not a function", call.CalleeString()))
+	}
+}
+
+// callMethod looks the method up in the receiver type's method table, then
+// among the fields of an object, and finally falls back to the builtin of
+// the same name with the receiver as first argument, so that s.close()
+// means close(s).
+func callMethod(receiver Value, n *MethodCallNode, args []Value, env *Environment) Value {
+	typeName := TypeOf(receiver).Name
+	if method, ok := env.Methods().Lookup(typeName, n.Method); ok {
+		return callFunction(env.thread, method.Bind(receiver), args, n.Pos)
+	}
+	if obj, ok := receiver.(*Object); ok {
+		if field, ok := obj.Get(n.Method); ok {
+			return callValue(env.thread, field, args, &FunctionCallNode{FunctionName: n.Method, Pos: n.Pos})
+		}
+	}
+	if builtin, ok := env.Get(n.Method); ok {
+		if fn, isBuiltIn := builtin.(BuiltinFunction); isBuiltIn {
+			return CallBuiltin(env.thread, fn, append([]Value{receiver}, args...), n.Pos)
+		}
+	}
+	panic(NewRuntimeError(n.Pos, "%s has no method %s", typeName, n.Method))
+}
+
+func CallBuiltin(thread *Thread, fn BuiltinFunction, args []Value, pos Position) Value {
+	defer RethrowAt(pos)
+	thread.At(pos)
+	return fn(thread, args)
+}
+
+func ConstructType(thread *Thread, t *DataType, args []Value, pos Position) Value {
+	defer RethrowAt(pos)
+	return t.Construct(thread, args, pos)
+}
+
+// callFunction runs function on thread. Every call is a preemption point.
+func callFunction(thread *Thread, function *Function, args []Value, pos Position) Value {
+	thread.Preempt(pos)
+
+	decl := function.Decl
+	if len(args) != len(decl.Parameters) {
+		panic(NewRuntimeError(pos, "Expected %d arguments but got %d", len(decl.Parameters), len(args)))
+	}
+
+	// the body runs in a scope enclosed by the function's defining scope,
+	// not by the caller's.
+	newEnv := NewEnclosedEnvironment(function.Env)
+	newEnv.thread = thread
+	if decl.Receiver != nil {
+		newEnv.Set("this", function.This)
+	}
+
+	// typed int locals live in unboxed slots of the frame when the body
+	// could be specialized.
+	body := decl.Body
+	specialized := specialize(decl)
+	if specialized != nil {
+		body = specialized.Body
+		newEnv.ints = make([]int, len(specialized.Slots))
+	}
+	for i, param := range decl.Parameters {
+		CheckType(param.Type, args[i], function.Env, pos, "argument "+param.Name+" of "+function.Name())
+		if specialized != nil {
+			if slot, ok := specialized.Slots[param.Name]; ok {
+				newEnv.ints[slot] = int(args[i].(Int))
+				continue
+			}
+		}
+		newEnv.Declare(param.Name, args[i], param.Type)
+	}
+
+	depth := thread.Enter(function.Name(), pos)
+	returned, ok := executeBlock(body, newEnv).(*returnValue)
+	thread.Unwind(depth)
+	if !ok {
+		if decl.ReturnType.Name != "nil" {
+			panic(NewRuntimeError(decl.Pos, "Missing return at end of function %s -> %s", function.Name(), decl.ReturnType))
+		}
+		return nil
+	}
+	if returned.Bare && decl.ReturnType.Name != "nil" {
+		panic(NewRuntimeError(returned.Pos, "Missing return value in function %s -> %s", function.Name(), decl.ReturnType))
+	}
+	CheckType(decl.ReturnType, returned.Value, function.Env, returned.Pos, "return value of "+function.Name())
+	return returned.Value
+}
+
+// CheckType raises a type error at pos unless value conforms to the
+// declared type t. what names the slot in the error message.
+func CheckType(t *TypeNode, value Value, env *Environment, pos Position, what string) {
+	defer RethrowAt(pos)
+	if !Conforms(t, value, env) {
+		panic(fmt.Sprintf("Cannot use %s as %s in %s", typeName(value), t, what))
+	}
+}
+
+// typeName names the type of value in type errors. Streams, lists and maps
+// name their element types, like stream[int] or list[string]; a list with
+// elements of different types is a list[any].
+func typeName(value Value) string {
+	switch v := value.(type) {
+	case *Stream:
+		return "stream[" + v.ElemType.Name + "]"
+	case *List:
+		if elements := v.Snapshot(); len(elements) > 0 {
+			return "list[" + commonTypeName(elements) + "]"
+		}
+	case *Map:
+		if keys, values := v.Snapshot(); len(keys) > 0 {
+			return "map[" + commonTypeName(keys) + ", " + commonTypeName(values) + "]"
+		}
+	}
+	return TypeOf(value).Name
+}
+
+// commonTypeName is the type name shared by all values, or any.
+func commonTypeName(values []Value) string {
+	name := typeName(values[0])
+	for _, value := range values[1:] {
+		if typeName(value) != name {
+			return "any"
+		}
+	}
+	return name
+}
@@ -0,0 +1,406 @@
+package up
+
+import "sync"
+
+// Typed int locals are specialized into unboxed frame slots: a function's
+// body is rewritten once so that reads and writes of `y: int` go to
+// Environment.ints instead of the map store, and arithmetic whose operands
+// are statically int runs without boxing or type assertions.
+
+// IntSlotNode reads a typed int local from its frame slot.
+type IntSlotNode struct {
+	Name string
+	Slot int
+	Pos  Position
+}
+
+func (n *IntSlotNode) String() string {
+	return n.Name
+}
+
+// IntSlotAssignmentNode stores into the frame slot of a typed int local.
+type IntSlotAssignmentNode struct {
+	Name  string
+	Slot  int
+	Value Node
+	Pos   Position
+}
+
+func (n *IntSlotAssignmentNode) String() string {
+	return n.Name + " = " + n.Value.String()
+}
+
+// IntBinOpNode is a BinOpNode whose operands are both statically int.
+type IntBinOpNode struct {
+	Left, Right Node
+	Op          string
+	Pos         Position
+}
+
+func (n *IntBinOpNode) String() string {
+	return "(" + n.Left.String() + " " + n.Op + " " + n.Right.String() + ")"
+}
+
+// specializedBody is the rewritten body of a function together with the
+// frame slot of every specialized local.
+type specializedBody struct {
+	Body  []Node
+	Slots map[string]int
+}
+
+// specializations caches specialize by declaration. Functions that cannot
+// be specialized are stored as a nil body.
+var specializations sync.Map
+
+// specialize returns the slot-based body of decl, or nil when decl has no
+// int local that can live in a slot.
+func specialize(decl *FuncDeclarationNode) *specializedBody {
+	if cached, ok := specializations.Load(decl); ok {
+		return cached.(*specializedBody)
+	}
+	body := buildSpecialization(decl)
+	cached, _ := specializations.LoadOrStore(decl, body)
+	return cached.(*specializedBody)
+}
+
+// IntSlots returns the frame slot of every typed int local of decl that
+// can live unboxed, or nil when there is none. The bytecode compiler uses
+// it to keep the same locals unboxed as the tree-walker.
+func IntSlots(decl *FuncDeclarationNode) map[string]int {
+	if specialized := specialize(decl); specialized != nil {
+		return specialized.Slots
+	}
+	return nil
+}
+
+func buildSpecialization(decl *FuncDeclarationNode) *specializedBody {
+	// closures capture the environment by name, so a function declaring
+	// one keeps every local in the store.
+	s := &slotScan{first: make(map[string]Node), excluded: make(map[string]bool), declared: make(map[string]bool)}
+	s.scanBlock(decl.Body, true)
+	if s.closure {
+		return nil
+	}
+
+	slots := make(map[string]int)
+	for _, param := range decl.Parameters {
+		if isIntType(param.Type) && !s.excluded[param.Name] {
+			slots[param.Name] = len(slots)
+		}
+	}
+	for _, stmt := range decl.Body {
+		assignment, ok := stmt.(*AssignmentNode)
+		if !ok || assignment.Type == nil || !s.declared[assignment.VarName] || s.excluded[assignment.VarName] {
+			continue
+		}
+		// a slot starts at zero, so the variable must not be read before
+		// its declaration.
+		if _, isSlot := slots[assignment.VarName]; !isSlot && s.first[assignment.VarName] == assignment {
+			slots[assignment.VarName] = len(slots)
+		}
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+
+	r := &slotRewriter{slots: slots}
+	return &specializedBody{Body: r.rewriteAll(decl.Body), Slots: slots}
+}
+
+func isIntType(t *TypeNode) bool {
+	return t != nil && t.Name == "int" && len(t.Params) == 0
+}
+
+// slotScan collects what decides which locals of a body can use a slot.
+type slotScan struct {
+	// first is the first node mentioning each name, in evaluation order.
+	first map[string]Node
+	// excluded names are bound in ways slots do not support.
+	excluded map[string]bool
+	// declared names have only top level `name: int` declarations.
+	declared map[string]bool
+	closure  bool
+}
+
+func (s *slotScan) mention(name string, node Node) {
+	if _, ok := s.first[name]; !ok {
+		s.first[name] = node
+	}
+}
+
+func (s *slotScan) scanBlock(body []Node, topLevel bool) {
+	for _, stmt := range body {
+		if assignment, ok := stmt.(*AssignmentNode); ok && assignment.Type != nil {
+			s.scan(assignment.Value)
+			s.mention(assignment.VarName, assignment)
+			if topLevel && isIntType(assignment.Type) {
+				if _, seen := s.declared[assignment.VarName]; !seen {
+					s.declared[assignment.VarName] = true
+				}
+			} else {
+				s.declared[assignment.VarName] = false
+				s.excluded[assignment.VarName] = true
+			}
+			continue
+		}
+		s.scan(stmt)
+	}
+}
+
+func (s *slotScan) scan(node Node) {
+	switch n := node.(type) {
+	case *IdentifierNode:
+		s.mention(n.Name, n)
+	case *AssignmentNode:
+		if n.Type != nil {
+			s.scanBlock([]Node{n}, false)
+			return
+		}
+		s.scan(n.Value)
+		s.mention(n.VarName, n)
+	case *FuncDeclarationNode:
+		s.closure = true
+	case *BinOpNode:
+		s.scan(n.Left)
+		s.scan(n.Right)
+	case *UnaryOpNode:
+		s.scan(n.Operand)
+	case *FunctionCallNode:
+		if n.Callee != nil {
+			s.scan(n.Callee)
+		} else {
+			s.excluded[n.FunctionName] = true
+		}
+		s.scanAll(n.Arguments)
+	case *MethodCallNode:
+		s.scan(n.Receiver)
+		s.scanAll(n.Arguments)
+	case *FieldAccessNode:
+		s.scan(n.Object)
+	case *FieldAssignmentNode:
+		s.scan(n.Object)
+		s.scan(n.Value)
+	case *IndexNode:
+		s.scan(n.Object)
+		s.scan(n.Index)
+	case *SliceNode:
+		s.scan(n.Object)
+		s.scan(n.Low)
+		s.scan(n.High)
+	case *IndexAssignmentNode:
+		s.scan(n.Object)
+		s.scan(n.Index)
+		s.scan(n.Value)
+	case *ListNode:
+		s.scanAll(n.Elements)
+	case *MapNode:
+		s.scanAll(n.Keys)
+		s.scanAll(n.Values)
+	case *SendNode:
+		s.scan(n.Stream)
+		s.scan(n.Value)
+	case *ReceiveNode:
+		s.scan(n.Stream)
+	case *ReturnNode:
+		s.scan(n.Value)
+	case *ThrowNode:
+		s.scan(n.Value)
+	case *SpawnNode:
+		s.scan(n.Call)
+	case *IfNode:
+		s.scan(n.Condition)
+		s.scanBlock(n.Then, false)
+		s.scanBlock(n.Else, false)
+	case *ForLoopNode:
+		s.excluded[n.Variable] = true
+		s.excluded[n.ValueVariable] = true
+		s.scan(n.Range)
+		s.scan(n.Iterable)
+		s.scanBlock(n.Body, false)
+	case *TryNode:
+		s.scanBlock(n.Body, false)
+		s.excluded[n.CatchVar] = true
+		s.scanBlock(n.Catch, false)
+	case *SelectNode:
+		for _, arm := range n.Cases {
+			if assignment, ok := arm.Comm.(*AssignmentNode); ok {
+				s.excluded[assignment.VarName] = true
+				s.scan(assignment.Value)
+			} else {
+				s.scan(arm.Comm)
+			}
+			s.scanBlock(arm.Body, false)
+		}
+		s.scan(n.Timeout)
+		s.scanBlock(n.Default, false)
+		s.scanBlock(n.TimeoutBody, false)
+	}
+}
+
+func (s *slotScan) scanAll(nodes []Node) {
+	for _, node := range nodes {
+		s.scan(node)
+	}
+}
+
+// slotRewriter copies a body, replacing slot locals and int arithmetic
+// with their specialized nodes. The original AST is left untouched.
+type slotRewriter struct {
+	slots map[string]int
+}
+
+func (r *slotRewriter) rewriteAll(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	rewritten := make([]Node, len(nodes))
+	for i, node := range nodes {
+		rewritten[i] = r.rewrite(node)
+	}
+	return rewritten
+}
+
+func (r *slotRewriter) rewrite(node Node) Node {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *IdentifierNode:
+		if slot, ok := r.slots[n.Name]; ok {
+			return &IntSlotNode{Name: n.Name, Slot: slot, Pos: n.Pos}
+		}
+		return n
+	case *AssignmentNode:
+		value := r.rewrite(n.Value)
+		if slot, ok := r.slots[n.VarName]; ok {
+			return &IntSlotAssignmentNode{Name: n.VarName, Slot: slot, Value: value, Pos: n.Pos}
+		}
+		return &AssignmentNode{VarName: n.VarName, Type: n.Type, Value: value, Pos: n.Pos}
+	case *BinOpNode:
+		left, right := r.rewrite(n.Left), r.rewrite(n.Right)
+		if isStaticInt(left) && isStaticInt(right) && n.Op != "&&" && n.Op != "||" {
+			return &IntBinOpNode{Left: left, Right: right, Op: n.Op, Pos: n.Pos}
+		}
+		return &BinOpNode{Left: left, Right: right, Op: n.Op, Pos: n.Pos}
+	case *UnaryOpNode:
+		return &UnaryOpNode{Op: n.Op, Operand: r.rewrite(n.Operand), Pos: n.Pos}
+	case *FunctionCallNode:
+		return r.rewriteCall(n)
+	case *MethodCallNode:
+		return &MethodCallNode{Receiver: r.rewrite(n.Receiver), Method: n.Method, Arguments: r.rewriteAll(n.Arguments), Pos: n.Pos}
+	case *FieldAccessNode:
+		return &FieldAccessNode{Object: r.rewrite(n.Object), Field: n.Field, Pos: n.Pos}
+	case *FieldAssignmentNode:
+		return &FieldAssignmentNode{Object: r.rewrite(n.Object), Field: n.Field, Op: n.Op, Value: r.rewrite(n.Value), Pos: n.Pos}
+	case *IndexNode:
+		return &IndexNode{Object: r.rewrite(n.Object), Index: r.rewrite(n.Index), Pos: n.Pos}
+	case *SliceNode:
+		return &SliceNode{Object: r.rewrite(n.Object), Low: r.rewrite(n.Low), High: r.rewrite(n.High), Pos: n.Pos}
+	case *IndexAssignmentNode:
+		return &IndexAssignmentNode{Object: r.rewrite(n.Object), Index: r.rewrite(n.Index), Op: n.Op, Value: r.rewrite(n.Value), Pos: n.Pos}
+	case *ListNode:
+		return &ListNode{Elements: r.rewriteAll(n.Elements), Pos: n.Pos}
+	case *MapNode:
+		return &MapNode{Keys: r.rewriteAll(n.Keys), Values: r.rewriteAll(n.Values), Pos: n.Pos}
+	case *SendNode:
+		return &SendNode{Stream: r.rewrite(n.Stream), Value: r.rewrite(n.Value), Pos: n.Pos}
+	case *ReceiveNode:
+		return &ReceiveNode{Stream: r.rewrite(n.Stream), Pos: n.Pos}
+	case *ReturnNode:
+		return &ReturnNode{Value: r.rewrite(n.Value), Pos: n.Pos}
+	case *ThrowNode:
+		return &ThrowNode{Value: r.rewrite(n.Value), Pos: n.Pos}
+	case *SpawnNode:
+		return &SpawnNode{Call: r.rewriteCall(n.Call), Pos: n.Pos}
+	case *IfNode:
+		return &IfNode{Condition: r.rewrite(n.Condition), Then: r.rewriteAll(n.Then), Else: r.rewriteAll(n.Else), Pos: n.Pos}
+	case *ForLoopNode:
+		return &ForLoopNode{Variable: n.Variable, ValueVariable: n.ValueVariable, Range: r.rewrite(n.Range), Iterable: r.rewrite(n.Iterable), Body: r.rewriteAll(n.Body), Pos: n.Pos}
+	case *TryNode:
+		return &TryNode{Body: r.rewriteAll(n.Body), CatchVar: n.CatchVar, Catch: r.rewriteAll(n.Catch), Pos: n.Pos}
+	case *SelectNode:
+		cases := make([]*SelectCaseNode, len(n.Cases))
+		for i, arm := range n.Cases {
+			// receive targets never use slots, and executeSelect needs
+			// the original form of the communication.
+			comm := arm.Comm
+			if send, ok := comm.(*SendNode); ok {
+				comm = r.rewrite(send)
+			}
+			cases[i] = &SelectCaseNode{Comm: comm, Body: r.rewriteAll(arm.Body)}
+		}
+		return &SelectNode{Cases: cases, HasDefault: n.HasDefault, Default: r.rewriteAll(n.Default), Timeout: r.rewrite(n.Timeout), TimeoutBody: r.rewriteAll(n.TimeoutBody), Pos: n.Pos}
+	default:
+		return n
+	}
+}
+
+func (r *slotRewriter) rewriteCall(n *FunctionCallNode) *FunctionCallNode {
+	return &FunctionCallNode{FunctionName: n.FunctionName, Callee: r.rewrite(n.Callee), Arguments: r.rewriteAll(n.Arguments), Pos: n.Pos}
+}
+
+// isStaticInt reports whether node always evaluates to an int.
+func isStaticInt(node Node) bool {
+	switch n := node.(type) {
+	case *IntNode, *IntSlotNode:
+		return true
+	case *IntBinOpNode:
+		switch n.Op {
+		case "+", "-", "*", "/", "%":
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateInt evaluates an expression that must produce an int without
+// boxing it when it is specialized. variable names the slot the value is
+// stored in, if any, for type errors.
+func evaluateInt(node Node, env *Environment, pos Position, variable string) int {
+	switch n := node.(type) {
+	case *IntNode:
+		return n.Value
+	case *IntSlotNode:
+		return env.ints[n.Slot]
+	case *IntBinOpNode:
+		if isStaticInt(n) {
+			return executeIntOp(n, env)
+		}
+	}
+	value := ExecuteNode(node, env)
+	i, ok := value.(Int)
+	if !ok {
+		if variable == "" {
+			panic(NewRuntimeError(pos, "Invalid operation between int and %s", TypeOf(value).Name))
+		}
+		panic(NewRuntimeError(pos, "Cannot use %s as int in variable %s", TypeOf(value).Name, variable))
+	}
+	return int(i)
+}
+
+func executeIntOp(n *IntBinOpNode, env *Environment) int {
+	left := evaluateInt(n.Left, env, n.Pos, "")
+	right := evaluateInt(n.Right, env, n.Pos, "")
+	return intArithmetic(n.Op, left, right, n.Pos)
+}
+
+// executeIntComparison evaluates the comparison operators of IntBinOpNode.
+func executeIntComparison(n *IntBinOpNode, env *Environment) bool {
+	left := evaluateInt(n.Left, env, n.Pos, "")
+	right := evaluateInt(n.Right, env, n.Pos, "")
+	switch n.Op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	case "<":
+		return left < right
+	case ">":
+		return left > right
+	case "<=":
+		return left <= right
+	case ">=":
+		return left >= right
+	default:
+		panic(NewRuntimeError(n.Pos, "Unknown operator: %s", n.Op))
+	}
+}
@@ -0,0 +1,149 @@
+package up
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Stream is the channel used by up threads to communicate with each other.
+// A stream with capacity 0 is unbuffered: every send waits for a receiver.
+type Stream struct {
+	ElemType *DataType
+	ch       chan Value
+	mu       sync.Mutex
+	closed   bool
+	// threads waiting on the stream in deterministic mode.
+	recvq []streamWaiter
+	sendq []streamWaiter
+	// sync orders sends before receives for the race detector.
+	sync syncClock
+}
+
+func NewStream(elemType *DataType, capacity int) *Stream {
+	if elemType == nil {
+		elemType = AnyType
+	}
+	if capacity < 0 {
+		panic(fmt.Sprintf("Stream capacity must not be negative, but got: %d", capacity))
+	}
+	return &Stream{ElemType: elemType, ch: make(chan Value, capacity)}
+}
+
+// constructStream backs the stream type when it is called as a function:
+// stream(), stream(capacity), stream(type) or stream(type, capacity).
+func constructStream(args []Value) *Stream {
+	elemType := AnyType
+	if len(args) > 0 {
+		if t, ok := args[0].(*DataType); ok {
+			elemType = t
+			args = args[1:]
+		}
+	}
+	switch len(args) {
+	case 0:
+		return NewStream(elemType, 0)
+	case 1:
+		capacity, ok := args[0].(Int)
+		if !ok {
+			panic(fmt.Sprintf("stream expects int capacity, but got: %s", TypeOf(args[0]).Name))
+		}
+		return NewStream(elemType, int(capacity))
+	default:
+		panic(fmt.Sprintf("stream expects at most 2 arguments but got %d", len(args)+1))
+	}
+}
+
+// Send waits until the stream takes value. The thread gives up its
+// processor while the stream is full.
+func (s *Stream) Send(thread *Thread, value Value) {
+	if !s.ElemType.Accepts(value) {
+		panic(fmt.Sprintf("Cannot send %s to stream of %s", TypeOf(value).Name, s.ElemType))
+	}
+	if thread.Racing() {
+		s.sync.release(thread)
+	}
+	s.put(thread, value, waitSend)
+}
+
+// put sends value, waiting for reason while the stream is full.
+func (s *Stream) put(thread *Thread, value Value, reason waitReason) {
+	if thread.deterministic() {
+		thread.sched.send(thread, s, value, reason)
+		return
+	}
+
+	// closing races with blocked senders, so a send on a stream closed
+	// in the meantime is turned into a regular runtime error.
+	defer func() {
+		if r := recover(); r != nil {
+			panic("Send on closed stream")
+		}
+	}()
+	select {
+	case s.ch <- value:
+	default:
+		thread.block(reason, func() { s.ch <- value })
+	}
+}
+
+// take receives a value if one is ready, without waiting.
+func (s *Stream) take(thread *Thread) bool {
+	if thread.deterministic() {
+		if len(s.ch) == 0 {
+			return false
+		}
+		s.receiveNow()
+		return true
+	}
+	select {
+	case <-s.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Receive waits for the next value. ok is false when the stream is closed
+// and drained. The thread gives up its processor while the stream is empty.
+func (s *Stream) Receive(thread *Thread) (value Value, ok bool) {
+	if thread.deterministic() {
+		value, ok = thread.sched.receive(thread, s)
+	} else {
+		select {
+		case value, ok = <-s.ch:
+		default:
+			thread.block(waitReceive, func() { value, ok = <-s.ch })
+		}
+	}
+	if thread.Racing() {
+		s.sync.acquire(thread)
+	}
+	return value, ok
+}
+
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		panic("Close of closed stream")
+	}
+	s.closed = true
+	close(s.ch)
+	s.wakeWaiters()
+}
+
+func (s *Stream) Len() int {
+	return len(s.ch)
+}
+
+func (s *Stream) Cap() int {
+	return cap(s.ch)
+}
+
+func (s *Stream) Type() *DataType {
+	return StreamType
+}
+
+func (s *Stream) String() string {
+	return fmt.Sprintf("stream<%s>(%d/%d)", s.ElemType, s.Len(), s.Cap())
+}
@@ -0,0 +1,81 @@
+package up
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestStress runs every program of stress/ on 1, 4 and 16 processors and
+// compares what it prints with the .out file next to it, like
+// stress/run.sh does for the driver.
+func TestStress(t *testing.T) {
+	files, err := filepath.Glob("../../stress/*.up")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no stress programs found: %v", err)
+	}
+	for _, file := range files {
+		expected, err := os.ReadFile(strings.TrimSuffix(file, ".up") + ".out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		program := parseFile(t, file)
+		for _, procs := range []int{1, 4, 16} {
+			t.Run(fmt.Sprintf("%s/procs=%d", filepath.Base(file), procs), func(t *testing.T) {
+				output := capture(t, func() {
+					env := NewEnvironment()
+					env.Threads().SetProcessors(procs)
+					if _, err := Run(program, env); err != nil {
+						t.Errorf("Uncaught error: %v", err)
+					}
+				})
+				if output != string(expected) {
+					t.Errorf("got output\n%s\nwant\n%s", output, expected)
+				}
+			})
+		}
+	}
+}
+
+func parseFile(tb testing.TB, file string) *ProgramNode {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return parseSource(tb, string(data))
+}
+
+func parseSource(tb testing.TB, source string) *ProgramNode {
+	tokens, err := Lexer(source)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	program, err := Parse(tokens)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return program
+}
+
+// capture runs f and returns what it printed on stdout. f must not stop
+// the test with Fatal, which would leave stdout redirected.
+func capture(tb testing.TB, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return <-output
+}
@@ -0,0 +1,561 @@
+package up
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// timeSlice is how long a thread may keep its processor while other
+// threads wait for one before it is asked to yield.
+const timeSlice = 10 * time.Millisecond
+
+// Thread is an up thread. Threads are multiplexed onto the processors of a
+// Scheduler: a thread only runs up code while it holds a processor, gives
+// it away while it waits on a stream, a select or sleep, and yields it at
+// preemption points once its time slice is over.
+type Thread struct {
+	ID   int64
+	Name string
+
+	sched   *Scheduler
+	fn      func(thread *Thread)
+	started bool
+	proc    *processor
+	wake    chan *processor
+	// preempt is set by the monitor when the thread should yield at the
+	// next preemption point.
+	preempt int32
+	// budget counts down the preemption points left before the thread
+	// yields in deterministic mode.
+	budget int
+
+	// frames is the call stack of the thread, for thread dumps.
+	frames []Frame
+	// waiting is what the thread waits for while parked, and paused is
+	// set while it is stopped for a dump. Both are guarded by sched.mu.
+	waiting waitReason
+	paused  bool
+	// clock is the vector clock of the thread for the race detector.
+	clock vectorClock
+}
+
+// processor is the right to run up code. Each one has a queue of runnable
+// threads; a processor with an empty queue steals from the others.
+type processor struct {
+	mu      sync.Mutex
+	runq    []*Thread
+	current *Thread
+	// switches counts the threads the processor started running, so the
+	// monitor can tell a thread that kept it for a whole time slice.
+	switches uint32
+}
+
+func (p *processor) push(t *Thread) {
+	p.mu.Lock()
+	p.runq = append(p.runq, t)
+	p.mu.Unlock()
+}
+
+func (p *processor) pop() *Thread {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.runq) == 0 {
+		return nil
+	}
+	t := p.runq[0]
+	p.runq = p.runq[1:]
+	return t
+}
+
+func (p *processor) setCurrent(t *Thread) {
+	p.mu.Lock()
+	p.current = t
+	if t != nil {
+		p.switches++
+	}
+	p.mu.Unlock()
+}
+
+// Scheduler runs the threads of a program on a fixed number of
+// processors, so any number of up threads share a bounded amount of
+// parallelism instead of competing for the CPU all at once.
+type Scheduler struct {
+	procs      []*processor
+	mu         sync.Mutex
+	idle       []*processor
+	global     []*Thread
+	monitoring bool
+	main       *Thread
+	wg         sync.WaitGroup
+	nextID     int64
+	// threads holds the threads that have not finished, for deadlock
+	// detection and dumps.
+	threads   map[int64]*Thread
+	wakeups   int64
+	detecting bool
+	stopping  int32
+	resume    chan struct{}
+	dumpMu    sync.Mutex
+	// race detection, see SetRaceDetection.
+	race     bool
+	raceMu   sync.Mutex
+	reported map[[2]Position]bool
+
+	spawned     int64
+	running     int64
+	runnable    int64
+	parked      int64
+	finished    int64
+	failed      int64
+	steals      int64
+	preemptions int64
+
+	// deterministic mode, see SetDeterministic.
+	seed     int64
+	rng      *rand.Rand
+	now      time.Duration
+	timers   []timer
+	timerSeq int64
+}
+
+// SchedulerStats is a snapshot of the scheduler counters.
+type SchedulerStats struct {
+	Processors  int
+	Spawned     int64
+	Running     int64
+	Runnable    int64
+	Parked      int64
+	Finished    int64
+	Failed      int64
+	Steals      int64
+	Preemptions int64
+}
+
+// NewScheduler creates a scheduler with one processor per CPU.
+func NewScheduler() *Scheduler {
+	s := &Scheduler{threads: map[int64]*Thread{}}
+	s.SetProcessors(runtime.GOMAXPROCS(0))
+	return s
+}
+
+// SetProcessors changes the number of processors. It must be called
+// before the first thread runs.
+func (s *Scheduler) SetProcessors(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.procs = make([]*processor, n)
+	s.idle = make([]*processor, n)
+	for i := range s.procs {
+		s.procs[i] = &processor{}
+		s.idle[n-1-i] = s.procs[i]
+	}
+}
+
+func (s *Scheduler) newThread(id int64, name string, fn func(thread *Thread)) *Thread {
+	t := &Thread{ID: id, Name: name, sched: s, fn: fn, wake: make(chan *processor, 1)}
+	if s.rng != nil {
+		t.budget = s.newBudget()
+	}
+	s.mu.Lock()
+	s.threads[id] = t
+	s.mu.Unlock()
+	return t
+}
+
+// Main registers the calling goroutine as the main thread of the program
+// and waits for a processor.
+func (s *Scheduler) Main() *Thread {
+	t := s.newThread(0, "main", nil)
+	t.started = true
+	if s.race {
+		t.clock.set(t.ID, 1)
+	}
+	s.main = t
+	s.acquire(t)
+	return t
+}
+
+// Spawn creates a thread running fn. It starts right away on an idle
+// processor, or waits in the queue of the spawning thread's processor.
+// Panics raised by the thread are reported on stderr instead of taking
+// down the whole process.
+func (s *Scheduler) Spawn(parent *Thread, name string, fn func(thread *Thread)) *Thread {
+	t := s.newThread(atomic.AddInt64(&s.nextID, 1), name, fn)
+	if s.race && parent != nil {
+		// the new thread sees everything its parent did so far.
+		t.clock = parent.clock.copy()
+		t.clock.set(t.ID, 1)
+		parent.clock.set(parent.ID, parent.clock.get(parent.ID)+1)
+	}
+	s.wg.Add(1)
+	atomic.AddInt64(&s.spawned, 1)
+
+	s.mu.Lock()
+	if p := s.popIdle(); p != nil {
+		s.mu.Unlock()
+		s.run(t, p)
+		return t
+	}
+	s.mu.Unlock()
+	if parent == nil || parent.proc == nil || s.rng != nil {
+		s.makeRunnable(t)
+	} else {
+		s.enqueue(parent.proc, t)
+	}
+	return t
+}
+
+// popIdle takes an idle processor. s.mu must be held.
+func (s *Scheduler) popIdle() *processor {
+	n := len(s.idle)
+	if n == 0 {
+		return nil
+	}
+	p := s.idle[n-1]
+	s.idle = s.idle[:n-1]
+	return p
+}
+
+// enqueue makes t runnable on the queue of p.
+func (s *Scheduler) enqueue(p *processor, t *Thread) {
+	s.mu.Lock()
+	atomic.AddInt64(&s.runnable, 1)
+	s.startMonitor()
+	s.mu.Unlock()
+	p.push(t)
+}
+
+// acquire waits until t holds a processor.
+func (s *Scheduler) acquire(t *Thread) {
+	s.mu.Lock()
+	if p := s.popIdle(); p != nil {
+		s.mu.Unlock()
+		s.assign(t, p)
+		t.checkStop()
+		return
+	}
+	s.global = append(s.global, t)
+	atomic.AddInt64(&s.runnable, 1)
+	s.startMonitor()
+	s.mu.Unlock()
+	<-t.wake
+	t.checkStop()
+}
+
+func (s *Scheduler) assign(t *Thread, p *processor) {
+	t.proc = p
+	p.setCurrent(t)
+	atomic.AddInt64(&s.running, 1)
+}
+
+// run hands the processor p to t, starting t if it never ran.
+func (s *Scheduler) run(t *Thread, p *processor) {
+	s.assign(t, p)
+	if t.started {
+		t.wake <- p
+		return
+	}
+	t.started = true
+	go s.start(t)
+}
+
+func (s *Scheduler) start(t *Thread) {
+	defer s.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddInt64(&s.failed, 1)
+			fmt.Fprintf(os.Stderr, "thread #%d %s failed: %v\n", t.ID, t.Name, r)
+		}
+		atomic.AddInt64(&s.running, -1)
+		atomic.AddInt64(&s.finished, 1)
+		s.mu.Lock()
+		delete(s.threads, t.ID)
+		s.mu.Unlock()
+		s.handoff(t.proc)
+	}()
+	t.checkStop()
+	t.fn(t)
+}
+
+// globalCheckInterval is how often a processor looks at the global queue
+// before its own, so threads waiting there are not starved by a processor
+// that always has local work.
+const globalCheckInterval = 61
+
+// next finds a runnable thread for p: from its own queue, the global
+// queue, or the queue of another processor.
+func (s *Scheduler) next(p *processor) *Thread {
+	if s.rng != nil {
+		return s.popRandom()
+	}
+	if p.switches%globalCheckInterval == 0 {
+		if t := s.popGlobal(); t != nil {
+			return t
+		}
+	}
+	if t := p.pop(); t != nil {
+		atomic.AddInt64(&s.runnable, -1)
+		return t
+	}
+	if t := s.popGlobal(); t != nil {
+		return t
+	}
+	return s.steal(p)
+}
+
+func (s *Scheduler) popGlobal() *Thread {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.global) == 0 {
+		return nil
+	}
+	t := s.global[0]
+	s.global = s.global[1:]
+	atomic.AddInt64(&s.runnable, -1)
+	return t
+}
+
+// makeRunnable puts t on the global queue.
+func (s *Scheduler) makeRunnable(t *Thread) {
+	s.mu.Lock()
+	s.global = append(s.global, t)
+	atomic.AddInt64(&s.runnable, 1)
+	s.startMonitor()
+	s.mu.Unlock()
+}
+
+// steal takes half of the queue of another processor, starting from a
+// random one, and returns the first stolen thread.
+func (s *Scheduler) steal(p *processor) *Thread {
+	offset := rand.Intn(len(s.procs))
+	for i := range s.procs {
+		victim := s.procs[(offset+i)%len(s.procs)]
+		if victim == p {
+			continue
+		}
+		victim.mu.Lock()
+		n := (len(victim.runq) + 1) / 2
+		if n == 0 {
+			victim.mu.Unlock()
+			continue
+		}
+		stolen := append([]*Thread(nil), victim.runq[:n]...)
+		victim.runq = victim.runq[n:]
+		victim.mu.Unlock()
+
+		atomic.AddInt64(&s.steals, 1)
+		atomic.AddInt64(&s.runnable, -1)
+		for _, t := range stolen[1:] {
+			p.push(t)
+		}
+		return stolen[0]
+	}
+	return nil
+}
+
+// handoff gives the processor p, released by its thread, to the next
+// runnable thread, or makes it idle.
+func (s *Scheduler) handoff(p *processor) {
+	p.setCurrent(nil)
+	for {
+		if t := s.next(p); t != nil {
+			s.run(t, p)
+			return
+		}
+		// in deterministic mode time only passes when nothing can run.
+		if s.fireTimers() {
+			continue
+		}
+		// threads waiting for a processor queue up under s.mu, so they
+		// either show up here or find p idle.
+		s.mu.Lock()
+		if len(s.global) == 0 {
+			s.idle = append(s.idle, p)
+			if len(s.idle) == len(s.procs) {
+				s.startDetector()
+			}
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// startMonitor starts the monitor unless it is running. s.mu must be held.
+func (s *Scheduler) startMonitor() {
+	if s.monitoring || s.rng != nil {
+		return
+	}
+	s.monitoring = true
+	go s.monitor()
+}
+
+// monitor asks threads that kept their processor for a whole time slice
+// to yield while other threads wait. It stops once nothing waits, so it
+// never keeps a program whose threads are all blocked alive.
+func (s *Scheduler) monitor() {
+	last := make([]uint32, len(s.procs))
+	ticker := time.NewTicker(timeSlice)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		if atomic.LoadInt64(&s.runnable) == 0 {
+			s.monitoring = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		for i, p := range s.procs {
+			p.mu.Lock()
+			if p.current != nil && p.switches == last[i] {
+				atomic.StoreInt32(&p.current.preempt, 1)
+			}
+			last[i] = p.switches
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Preempt is a preemption point, reached on every loop iteration and
+// function call. The thread yields its processor when the monitor asked
+// it to and another thread is waiting. Preempted threads go to the global
+// queue, behind threads woken up in the meantime. In deterministic mode the
+// thread yields once its budget of preemption points is spent instead.
+// pos is where the innermost call is at, which a dump shows while the
+// thread is stopped there.
+func (t *Thread) Preempt(pos Position) {
+	// the check is kept small enough to be inlined.
+	if t != nil && (t.budget > 0 || atomic.LoadInt32(&t.preempt) != 0) {
+		t.preemptSlow(pos)
+	}
+}
+
+func (t *Thread) preemptSlow(pos Position) {
+	t.At(pos)
+	if atomic.LoadInt32(&t.preempt) != 0 {
+		atomic.StoreInt32(&t.preempt, 0)
+		if atomic.LoadInt32(&t.sched.stopping) != 0 {
+			t.sched.pause(t)
+		} else if t.budget == 0 {
+			t.yield()
+			return
+		}
+	}
+	if t.budget > 0 {
+		t.budget--
+		if t.budget == 0 {
+			t.budget = t.sched.newBudget()
+			t.yield()
+		}
+	}
+}
+
+// yield gives the processor of t to the next runnable thread, if any.
+func (t *Thread) yield() {
+	s, p := t.sched, t.proc
+	next := s.next(p)
+	if next == nil {
+		return
+	}
+	atomic.AddInt64(&s.preemptions, 1)
+	atomic.AddInt64(&s.running, -1)
+	t.proc = nil
+	s.makeRunnable(t)
+	s.run(next, p)
+	<-t.wake
+	t.checkStop()
+}
+
+// block runs wait, which blocks outside the interpreter for reason,
+// without holding a processor, and waits for one again afterwards.
+func (t *Thread) block(reason waitReason, wait func()) {
+	if t == nil {
+		wait()
+		return
+	}
+	t.park(reason)
+	defer t.unpark()
+	wait()
+}
+
+func (t *Thread) park(reason waitReason) {
+	s, p := t.sched, t.proc
+	t.proc = nil
+	s.mu.Lock()
+	t.waiting = reason
+	s.mu.Unlock()
+	atomic.AddInt64(&s.running, -1)
+	atomic.AddInt64(&s.parked, 1)
+	s.handoff(p)
+}
+
+func (t *Thread) unpark() {
+	s := t.sched
+	s.mu.Lock()
+	t.waiting = notWaiting
+	s.wakeups++
+	s.mu.Unlock()
+	atomic.AddInt64(&s.parked, -1)
+	s.acquire(t)
+}
+
+// Wait releases the processor of the main thread and blocks until every
+// spawned thread has finished.
+func (s *Scheduler) Wait() {
+	main := s.main
+	if main != nil && main.proc != nil {
+		main.park(waitThreads)
+	}
+	s.wg.Wait()
+	if main != nil {
+		atomic.AddInt64(&s.parked, -1)
+		s.mu.Lock()
+		delete(s.threads, main.ID)
+		s.mu.Unlock()
+		s.main = nil
+	}
+}
+
+func (s *Scheduler) Stats() SchedulerStats {
+	return SchedulerStats{
+		Processors:  len(s.procs),
+		Spawned:     atomic.LoadInt64(&s.spawned),
+		Running:     atomic.LoadInt64(&s.running),
+		Runnable:    atomic.LoadInt64(&s.runnable),
+		Parked:      atomic.LoadInt64(&s.parked),
+		Finished:    atomic.LoadInt64(&s.finished),
+		Failed:      atomic.LoadInt64(&s.failed),
+		Steals:      atomic.LoadInt64(&s.steals),
+		Preemptions: atomic.LoadInt64(&s.preemptions),
+	}
+}
+
+func (s *Scheduler) Visualize() {
+	stats := s.Stats()
+	fmt.Println("+------------------+------------+")
+	fmt.Printf("| %-16s | %10s |\n", "Threads", "Count")
+	fmt.Println("+------------------+------------+")
+	fmt.Printf("| %-16s | %10d |\n", "processors", stats.Processors)
+	fmt.Printf("| %-16s | %10d |\n", "spawned", stats.Spawned)
+	fmt.Printf("| %-16s | %10d |\n", "running", stats.Running)
+	fmt.Printf("| %-16s | %10d |\n", "runnable", stats.Runnable)
+	fmt.Printf("| %-16s | %10d |\n", "parked", stats.Parked)
+	fmt.Printf("| %-16s | %10d |\n", "finished", stats.Finished)
+	fmt.Printf("| %-16s | %10d |\n", "failed", stats.Failed)
+	fmt.Printf("| %-16s | %10d |\n", "steals", stats.Steals)
+	fmt.Printf("| %-16s | %10d |\n", "preemptions", stats.Preemptions)
+	if seed, ok := s.Seed(); ok {
+		fmt.Printf("| %-16s | %10d |\n", "seed", seed)
+	}
+	if s.race {
+		fmt.Printf("| %-16s | %10d |\n", "races", s.Races())
+	}
+	fmt.Println("+------------------+------------+")
+}
@@ -0,0 +1,179 @@
+package up
+
+import "fmt"
+
+// DataType is the runtime value of a type name such as int or string.
+// Type names are predeclared in the global environment so they can be
+// passed around, e.g. stream(int, 3).
+type DataType struct {
+	Name string
+	// Class is set for types declared with the class keyword.
+	Class *Class
+}
+
+var (
+	AnyType    = &DataType{Name: "any"}
+	NilType    = &DataType{Name: "nil"}
+	BoolType   = &DataType{Name: "bool"}
+	IntType    = &DataType{Name: "int"}
+	FloatType  = &DataType{Name: "float"}
+	ByteType   = &DataType{Name: "byte"}
+	StringType = &DataType{Name: "string"}
+	StreamType = &DataType{Name: "stream"}
+	FuncType   = &DataType{Name: "func"}
+	TypeType   = &DataType{Name: "type"}
+)
+
+var builtinTypes = []*DataType{AnyType, BoolType, IntType, FloatType, ByteType, StringType, ListType, MapType, StreamType, MutexType, ErrorType}
+
+func (t *DataType) Type() *DataType {
+	return TypeType
+}
+
+func (t *DataType) String() string {
+	if t.Class != nil {
+		return "class " + t.Name
+	}
+	return t.Name
+}
+
+// Construct is called when a type name is used like a function. pos is the
+// position of the call.
+func (t *DataType) Construct(thread *Thread, args []Value, pos Position) Value {
+	if t.Class != nil {
+		return t.Class.construct(thread, t, args, pos)
+	}
+	switch t {
+	case ListType:
+		return NewList(append([]Value{}, args...))
+	case MapType:
+		if len(args) != 0 {
+			panic(fmt.Sprintf("map expects no arguments but got %d", len(args)))
+		}
+		return NewMap()
+	case StreamType:
+		return constructStream(args)
+	case MutexType:
+		return constructMutex(args)
+	case ErrorType:
+		return constructError(args)
+	case IntType:
+		return convertToInt(conversionArgument(t, args))
+	case FloatType:
+		return convertToFloat(conversionArgument(t, args))
+	case BoolType:
+		return convertToBool(conversionArgument(t, args))
+	case StringType:
+		return String(stringify(conversionArgument(t, args)))
+	default:
+		panic("Type " + t.Name + " is not callable")
+	}
+}
+
+// Accepts reports whether value can be stored in a slot of this type.
+func (t *DataType) Accepts(value Value) bool {
+	if t == AnyType {
+		return true
+	}
+	return TypeOf(value) == t
+}
+
+// TypeOf returns the type of a runtime value. Method receivers are looked
+// up by the name of this type.
+func TypeOf(value Value) *DataType {
+	if value == nil {
+		return NilType
+	}
+	return value.Type()
+}
+
+// valueTypes are the types whose slots can never hold nil.
+var valueTypes = map[string]bool{"int": true, "float": true, "string": true, "bool": true, "byte": true}
+
+// canonicalTypeName resolves the str alias of string.
+func canonicalTypeName(name string) string {
+	if name == "str" {
+		return "string"
+	}
+	return name
+}
+
+// isType reports whether the type expression t is the type d. A runtime
+// type has no parameters, so list[int] is not the type list.
+func isType(t *TypeNode, d *DataType, env *Environment) bool {
+	if len(t.Params) > 0 || t.ReturnType != nil {
+		return false
+	}
+	declared, ok := env.Get(t.Name)
+	return ok && declared == d
+}
+
+// Conforms reports whether value may be stored in a slot declared with the
+// type expression t. Type names are resolved in env, so classes work as
+// types. Element types of lists and maps are checked for the elements the
+// container holds at the time of the check.
+func Conforms(t *TypeNode, value Value, env *Environment) bool {
+	switch t.Name {
+	case "any":
+		return true
+	case "nil":
+		return value == nil
+	}
+	if value == nil {
+		return !valueTypes[canonicalTypeName(t.Name)]
+	}
+
+	switch t.Name {
+	case "func":
+		switch fn := value.(type) {
+		case Callable:
+			return fn.Arity() == len(t.Params)
+		case BuiltinFunction:
+			return true
+		}
+		return false
+	case "list":
+		list, ok := value.(*List)
+		if !ok {
+			return false
+		}
+		if len(t.Params) == 1 {
+			for _, element := range list.Snapshot() {
+				if !Conforms(t.Params[0], element, env) {
+					return false
+				}
+			}
+		}
+		return true
+	case "map":
+		m, ok := value.(*Map)
+		if !ok {
+			return false
+		}
+		if len(t.Params) == 2 {
+			keys, values := m.Snapshot()
+			for i, key := range keys {
+				if !Conforms(t.Params[0], key, env) || !Conforms(t.Params[1], values[i], env) {
+					return false
+				}
+			}
+		}
+		return true
+	case "stream":
+		stream, ok := value.(*Stream)
+		if !ok {
+			return false
+		}
+		return len(t.Params) == 0 || isType(t.Params[0], stream.ElemType, env)
+	}
+
+	declared, ok := env.Get(t.Name)
+	if !ok {
+		panic(fmt.Sprintf("Unknown type: %s", t.Name))
+	}
+	dataType, ok := declared.(*DataType)
+	if !ok {
+		panic(fmt.Sprintf("%s is not a type", t.Name))
+	}
+	return dataType.Accepts(value)
+}
@@ -0,0 +1,148 @@
+package up
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+)
+
+// Value is a runtime value of up. Every value knows its type and how it is
+// printed. The nil Value is up's nil.
+//
+// Scalars are the named types below; lists, maps, streams, functions,
+// objects, errors and types are pointers and compare by identity.
+type Value interface {
+	Type() *DataType
+	String() string
+}
+
+type (
+	Bool   bool
+	Int    int
+	Float  float64
+	Byte   byte
+	String string
+)
+
+func (v Bool) Type() *DataType   { return BoolType }
+func (v Int) Type() *DataType    { return IntType }
+func (v Float) Type() *DataType  { return FloatType }
+func (v Byte) Type() *DataType   { return ByteType }
+func (v String) Type() *DataType { return StringType }
+
+func (v Bool) String() string   { return strconv.FormatBool(bool(v)) }
+func (v Int) String() string    { return strconv.Itoa(int(v)) }
+func (v Float) String() string  { return strconv.FormatFloat(float64(v), 'g', -1, 64) }
+func (v Byte) String() string   { return strconv.Itoa(int(v)) }
+func (v String) String() string { return string(v) }
+
+// Truthy decides how a value behaves in conditions and logical operators.
+func Truthy(value Value) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case Bool:
+		return bool(v)
+	case Int:
+		return v != 0
+	case Float:
+		return v != 0
+	case Byte:
+		return v != 0
+	case String:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+// Equal is the == of up. Scalars compare by value, where an int equals a
+// float or a byte of the same value; everything else compares by identity.
+func Equal(a, b Value) bool {
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case Int:
+		switch y := b.(type) {
+		case Int:
+			return x == y
+		case Float:
+			return Float(x) == y
+		case Byte:
+			return x == Int(y)
+		}
+		return false
+	case Float:
+		switch y := b.(type) {
+		case Float:
+			return x == y
+		case Int:
+			return x == Float(y)
+		case Byte:
+			return x == Float(y)
+		}
+		return false
+	case Byte:
+		return Equal(Int(x), b)
+	case BuiltinFunction:
+		// Go funcs are not comparable; a builtin only equals itself, which
+		// cannot be told apart here.
+		return false
+	}
+	if _, ok := b.(BuiltinFunction); ok {
+		return false
+	}
+	return a == b
+}
+
+// Hashable reports whether value can be a map key. Keys must compare by
+// value, so only nil and scalars are hashable.
+func Hashable(value Value) bool {
+	switch value.(type) {
+	case nil, Bool, Int, Float, Byte, String:
+		return true
+	}
+	return false
+}
+
+// Hash returns the hash of a hashable value. Values that are Equal hash
+// alike, so an int and the float of the same value find the same key.
+func Hash(value Value) uint64 {
+	h := fnv.New64a()
+	switch v := value.(type) {
+	case nil:
+		h.Write([]byte{'n'})
+	case Bool:
+		if v {
+			h.Write([]byte{'b', 1})
+		} else {
+			h.Write([]byte{'b', 0})
+		}
+	case Int:
+		h.Write([]byte{'i'})
+		h.Write([]byte(strconv.Itoa(int(v))))
+	case Float:
+		if f := float64(v); f == math.Trunc(f) && f >= float64(minInt) && f < -float64(minInt) {
+			return Hash(Int(f))
+		}
+		h.Write([]byte{'f'})
+		h.Write([]byte(strconv.FormatUint(math.Float64bits(float64(v)), 16)))
+	case Byte:
+		return Hash(Int(v))
+	case String:
+		h.Write([]byte{'s'})
+		h.Write([]byte(v))
+	default:
+		panic("Unhashable value: " + TypeOf(value).Name)
+	}
+	return h.Sum64()
+}
+
+// stringify returns the string form of a value, as print shows it and as
+// it is concatenated to strings with +.
+func stringify(value Value) string {
+	if value == nil {
+		return "nil"
+	}
+	return value.String()
+}
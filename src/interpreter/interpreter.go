@@ -2,9 +2,14 @@ package up
 
 import (
 	"fmt"
+	"math/rand"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	core "github.com/KennethanCeyer/up/src/core"
+	vm "github.com/KennethanCeyer/up/src/vm"
 )
 
 func Execute(filepath string, options *core.Options) {
@@ -14,6 +19,15 @@ func Execute(filepath string, options *core.Options) {
 		return
 	}
 
+	// a fresh .upc file next to the source skips lexing, parsing and
+	// compiling. Checking needs the syntax tree, so it always starts over.
+	if options.VM && !options.Check {
+		if module, err := vm.LoadCache(filepath, data); err == nil {
+			runModule(module, options)
+			return
+		}
+	}
+
 	tokens, err := core.Lexer(string(data))
 	if err != nil {
 		fmt.Println("Error in lexical analysis:", err)
@@ -36,11 +50,93 @@ func Execute(filepath string, options *core.Options) {
 		core.VisualizeNode(ast)
 	}
 
-	env := core.NewEnvironment()
-	core.ExecuteNode(ast, env)
+	if options.Check {
+		if errs := core.Check(ast); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Println("Error in checking:", err)
+			}
+			return
+		}
+	}
+
+	if options.VM {
+		module, err := vm.Compile(ast)
+		if err != nil {
+			fmt.Println("Error in compiling:", err)
+			return
+		}
+		if err := vm.WriteCache(filepath, module, data); err != nil && options.Debug {
+			fmt.Println("Cannot write bytecode cache:", err)
+		}
+		runModule(module, options)
+		return
+	}
 
+	env := newEnvironment(options)
+	_, err = core.Run(ast, env)
+	report(env, err, options)
+}
+
+func runModule(module *vm.Module, options *core.Options) {
 	// for logging.
 	if options.Debug {
+		fmt.Print(module.Disassemble())
+	}
+
+	env := newEnvironment(options)
+	_, err := vm.New(module, env).Run()
+	report(env, err, options)
+}
+
+func newEnvironment(options *core.Options) *core.Environment {
+	env := core.NewEnvironment()
+	if options.Procs > 0 {
+		env.Threads().SetProcessors(options.Procs)
+	}
+	if options.Deterministic || options.Seed != 0 {
+		seed := options.Seed
+		for seed == 0 {
+			seed = rand.New(rand.NewSource(time.Now().UnixNano())).Int63()
+		}
+		env.Threads().SetDeterministic(seed)
+	}
+	if options.Race {
+		env.Threads().SetRaceDetection()
+	}
+	dumpOnSignal(env)
+	return env
+}
+
+// dumpOnSignal writes a dump of every thread to stderr whenever the
+// process receives SIGQUIT, and lets the program go on.
+func dumpOnSignal(env *core.Environment) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGQUIT)
+	go func() {
+		for range signals {
+			env.Threads().Dump(os.Stderr)
+		}
+	}()
+}
+
+// report prints the uncaught error of a run, and the final state of the
+// program when asked for.
+func report(env *core.Environment, err error, options *core.Options) {
+	if err != nil {
+		fmt.Println("Uncaught error:", err)
+	} else if options.Debug {
+		// for logging.
 		env.Visualize()
 	}
+	if options.Stats || options.Debug && err == nil {
+		env.Threads().Visualize()
+	}
+	if races := env.Threads().Races(); races > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d data race(s)\n", races)
+	}
+	// a seed picked at random is always printed, since a run with a wrong
+	// result needs replaying as much as a failed one.
+	if err != nil || env.Threads().Stats().Failed > 0 || env.Threads().Races() > 0 || options.Seed == 0 {
+		env.Threads().ReportSeed(os.Stderr)
+	}
 }
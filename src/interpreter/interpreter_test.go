@@ -0,0 +1,70 @@
+package up
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	core "github.com/KennethanCeyer/up/src/core"
+	vm "github.com/KennethanCeyer/up/src/vm"
+)
+
+// TestStaleCache runs a program whose .upc file was compiled from an older
+// version of it. The program must be compiled again, and the cache
+// replaced, instead of running the old bytecode.
+func TestStaleCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "program.up")
+	stale := []byte("func main() -> nil {\n    print(\"stale\")\n}\n")
+	source := []byte(strings.Replace(string(stale), "stale", "fresh", 1))
+	if err := os.WriteFile(path, source, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.WriteCache(path, compile(t, stale), stale); err != nil {
+		t.Fatal(err)
+	}
+
+	output := capture(t, func() { Execute(path, &core.Options{VM: true}) })
+	if output != "fresh\n" {
+		t.Errorf("got output %q, want %q", output, "fresh\n")
+	}
+	if _, err := vm.LoadCache(path, source); err != nil {
+		t.Errorf("the cache was not replaced: %v", err)
+	}
+}
+
+func compile(t *testing.T, source []byte) *vm.Module {
+	tokens, err := core.Lexer(string(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := core.Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, err := vm.Compile(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return module
+}
+
+// capture runs f and returns what it printed on stdout.
+func capture(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return <-output
+}
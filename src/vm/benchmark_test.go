@@ -0,0 +1,32 @@
+package up
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	core "github.com/KennethanCeyer/up/src/core"
+)
+
+// BenchmarkPrograms runs the typed and untyped program pairs of
+// benchmarks/ on the VM, like benchmarks/run.sh does for the driver with
+// -vm. Compiling is not measured.
+func BenchmarkPrograms(b *testing.B) {
+	files, err := filepath.Glob("../../benchmarks/*.up")
+	if err != nil || len(files) == 0 {
+		b.Fatalf("no benchmark programs found: %v", err)
+	}
+	for _, file := range files {
+		module := compileFile(b, file)
+		b.Run(strings.TrimSuffix(filepath.Base(file), ".up"), func(b *testing.B) {
+			capture(b, func() {
+				for i := 0; i < b.N; i++ {
+					if _, err := New(module, core.NewEnvironment()).Run(); err != nil {
+						b.Errorf("Uncaught error: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
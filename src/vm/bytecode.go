@@ -0,0 +1,453 @@
+package up
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+
+	core "github.com/KennethanCeyer/up/src/core"
+)
+
+// FormatVersion is the version of the binary module format. It changes
+// whenever the layout or the meaning of the instructions changes, so that
+// files written by another version are recompiled.
+const FormatVersion = 5
+
+// magic starts every encoded module.
+var magic = []byte("UPC\x00")
+
+// The encoded module is
+//
+//	magic, version, SHA-256 of the source,
+//	constants, names, types, globals, prototypes, classes,
+//	program classes, program functions,
+//	CRC-32 of everything before it.
+//
+// Integers are varints, strings are a length followed by the bytes and
+// lists are a count followed by the elements. Every prototype carries its
+// line table, the source position of each instruction, so errors raised by
+// a loaded module point at the same place as a freshly compiled one.
+const (
+	constantInt byte = iota
+	constantFloat
+	constantString
+	constantBool
+)
+
+// SourceHash identifies the source a module was compiled from.
+func SourceHash(source []byte) [sha256.Size]byte {
+	return sha256.Sum256(source)
+}
+
+// Encode serializes a module compiled from source.
+func Encode(m *Module, source []byte) []byte {
+	w := &writer{}
+	w.buf.Write(magic)
+	w.uint(FormatVersion)
+	hash := SourceHash(source)
+	w.buf.Write(hash[:])
+
+	w.uint(len(m.Constants))
+	for _, constant := range m.Constants {
+		w.constant(constant)
+	}
+	w.strings(m.Names)
+	w.uint(len(m.Types))
+	for _, t := range m.Types {
+		w.typeNode(t)
+	}
+	w.strings(m.Globals)
+	w.uint(len(m.Prototypes))
+	for _, proto := range m.Prototypes {
+		w.prototype(proto)
+	}
+	w.uint(len(m.Classes))
+	for _, class := range m.Classes {
+		w.class(class)
+	}
+	w.ints(m.ProgramClasses)
+	w.ints(m.ProgramFunctions)
+
+	var checksum [4]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(w.buf.Bytes()))
+	w.buf.Write(checksum[:])
+	return w.buf.Bytes()
+}
+
+// Decode loads a module encoded by Encode. It fails when the data is
+// damaged, was written by another format version or was compiled from a
+// source other than source.
+func Decode(data []byte, source []byte) (module *Module, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			message, ok := r.(string)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", message)
+		}
+	}()
+
+	if len(data) < len(magic)+4 || !bytes.Equal(data[:len(magic)], magic) {
+		return nil, fmt.Errorf("not a compiled up module")
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, fmt.Errorf("compiled module is damaged")
+	}
+
+	r := &reader{data: body[len(magic):]}
+	if version := r.uint(); version != FormatVersion {
+		return nil, fmt.Errorf("compiled module has format version %d, expected %d", version, FormatVersion)
+	}
+	hash := SourceHash(source)
+	if !bytes.Equal(r.bytes(len(hash)), hash[:]) {
+		return nil, fmt.Errorf("compiled module is out of date")
+	}
+
+	m := &Module{}
+	m.Constants = make([]core.Value, r.count())
+	for i := range m.Constants {
+		m.Constants[i] = r.constant()
+	}
+	m.Names = r.strings()
+	m.Types = make([]*core.TypeNode, r.count())
+	for i := range m.Types {
+		m.Types[i] = r.typeNode()
+	}
+	m.Globals = r.strings()
+	m.Prototypes = make([]*Prototype, r.count())
+	for i := range m.Prototypes {
+		m.Prototypes[i] = r.prototype()
+	}
+	m.Classes = make([]*Class, r.count())
+	for i := range m.Classes {
+		m.Classes[i] = r.class()
+	}
+	m.ProgramClasses = r.ints()
+	m.ProgramFunctions = r.ints()
+	if len(r.data) != 0 {
+		panic("compiled module has trailing data")
+	}
+	return m, nil
+}
+
+type writer struct {
+	buf bytes.Buffer
+}
+
+func (w *writer) uint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (w *writer) int(n int) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutVarint(b[:], int64(n))])
+}
+
+func (w *writer) bool(b bool) {
+	if b {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+func (w *writer) string(s string) {
+	w.uint(len(s))
+	w.buf.WriteString(s)
+}
+
+func (w *writer) strings(list []string) {
+	w.uint(len(list))
+	for _, s := range list {
+		w.string(s)
+	}
+}
+
+func (w *writer) ints(list []int) {
+	w.uint(len(list))
+	for _, n := range list {
+		w.int(n)
+	}
+}
+
+func (w *writer) position(pos core.Position) {
+	w.int(pos.Row)
+	w.int(pos.Col)
+}
+
+func (w *writer) constant(value core.Value) {
+	switch v := value.(type) {
+	case core.Int:
+		w.buf.WriteByte(constantInt)
+		w.int(int(v))
+	case core.Float:
+		w.buf.WriteByte(constantFloat)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(float64(v)))
+		w.buf.Write(b[:])
+	case core.String:
+		w.buf.WriteByte(constantString)
+		w.string(string(v))
+	case core.Bool:
+		w.buf.WriteByte(constantBool)
+		w.bool(bool(v))
+	default:
+		panic(fmt.Sprintf("Cannot encode constant %s", core.TypeOf(value).Name))
+	}
+}
+
+func (w *writer) typeNode(t *core.TypeNode) {
+	w.string(t.Name)
+	w.position(t.Pos)
+	w.uint(len(t.Params))
+	for _, param := range t.Params {
+		w.typeNode(param)
+	}
+	w.bool(t.ReturnType != nil)
+	if t.ReturnType != nil {
+		w.typeNode(t.ReturnType)
+	}
+}
+
+func (w *writer) slot(cell bool, index int) {
+	w.bool(cell)
+	w.int(index)
+}
+
+func (w *writer) prototype(p *Prototype) {
+	w.string(p.Name)
+	w.string(p.Signature)
+	w.string(p.Receiver)
+	w.strings(p.Params)
+	w.ints(p.ParamTypes)
+	w.int(p.ReturnType)
+	w.position(p.Pos)
+
+	w.uint(len(p.ParamSlots))
+	for _, slot := range p.ParamSlots {
+		w.slot(slot.Cell, slot.Index)
+		w.bool(slot.Int)
+	}
+	w.slot(p.This.Cell, p.This.Index)
+	w.uint(p.NumLocals)
+	w.uint(p.NumCells)
+	w.uint(p.NumInts)
+	w.uint(p.MaxStack)
+	w.uint(len(p.Captures))
+	for _, capture := range p.Captures {
+		w.slot(capture.Cell, capture.Index)
+	}
+	w.bool(p.HasTry)
+
+	w.uint(len(p.Code))
+	for i, in := range p.Code {
+		w.buf.WriteByte(byte(in.Op))
+		w.int(int(in.A))
+		w.int(int(in.B))
+		w.int(int(in.C))
+		w.position(p.Lines[i])
+	}
+
+	w.uint(len(p.Selects))
+	for _, table := range p.Selects {
+		w.uint(len(table.Arms))
+		for _, arm := range table.Arms {
+			w.bool(arm.Send)
+			w.bool(arm.Assign)
+			w.int(arm.Target)
+		}
+		w.bool(table.HasTimeout)
+		w.string(table.TimeoutSource)
+		w.int(table.TimeoutTarget)
+		w.bool(table.HasDefault)
+		w.int(table.DefaultTarget)
+	}
+}
+
+func (w *writer) class(c *Class) {
+	w.string(c.Decl.Name)
+	w.position(c.Decl.Pos)
+	w.uint(len(c.Decl.Fields))
+	for _, field := range c.Decl.Fields {
+		w.string(field.Name)
+		w.typeNode(field.Type)
+		w.position(field.Pos)
+	}
+	w.ints(c.Defaults)
+	w.ints(c.Methods)
+}
+
+// reader decodes what writer wrote. Malformed data panics with a message,
+// which Decode returns as its error.
+type reader struct {
+	data []byte
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || n > len(r.data) {
+		panic("compiled module is truncated")
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *reader) uint() int {
+	n, size := binary.Uvarint(r.data)
+	if size <= 0 || n > math.MaxInt32 {
+		panic("compiled module has a malformed number")
+	}
+	r.data = r.data[size:]
+	return int(n)
+}
+
+func (r *reader) int() int {
+	n, size := binary.Varint(r.data)
+	if size <= 0 {
+		panic("compiled module has a malformed number")
+	}
+	r.data = r.data[size:]
+	return int(n)
+}
+
+// count reads the length of a list. Every element takes at least a byte,
+// so a count larger than the remaining data is malformed.
+func (r *reader) count() int {
+	n := r.uint()
+	if n > len(r.data) {
+		panic("compiled module is truncated")
+	}
+	return n
+}
+
+func (r *reader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *reader) string() string {
+	return string(r.bytes(r.uint()))
+}
+
+func (r *reader) strings() []string {
+	list := make([]string, r.count())
+	for i := range list {
+		list[i] = r.string()
+	}
+	return list
+}
+
+func (r *reader) ints() []int {
+	list := make([]int, r.count())
+	for i := range list {
+		list[i] = r.int()
+	}
+	return list
+}
+
+func (r *reader) position() core.Position {
+	row := r.int()
+	return core.Position{Row: row, Col: r.int()}
+}
+
+func (r *reader) constant() core.Value {
+	switch tag := r.byte(); tag {
+	case constantInt:
+		return core.Int(r.int())
+	case constantFloat:
+		return core.Float(math.Float64frombits(binary.LittleEndian.Uint64(r.bytes(8))))
+	case constantString:
+		return core.String(r.string())
+	case constantBool:
+		return core.Bool(r.bool())
+	default:
+		panic(fmt.Sprintf("compiled module has an unknown constant kind %d", tag))
+	}
+}
+
+func (r *reader) typeNode() *core.TypeNode {
+	t := &core.TypeNode{Name: r.string(), Pos: r.position()}
+	if n := r.count(); n > 0 {
+		t.Params = make([]*core.TypeNode, n)
+		for i := range t.Params {
+			t.Params[i] = r.typeNode()
+		}
+	}
+	if r.bool() {
+		t.ReturnType = r.typeNode()
+	}
+	return t
+}
+
+func (r *reader) slot() (bool, int) {
+	cell := r.bool()
+	return cell, r.int()
+}
+
+func (r *reader) prototype() *Prototype {
+	p := &Prototype{
+		Name:       r.string(),
+		Signature:  r.string(),
+		Receiver:   r.string(),
+		Params:     r.strings(),
+		ParamTypes: r.ints(),
+		ReturnType: r.int(),
+		Pos:        r.position(),
+	}
+
+	p.ParamSlots = make([]Slot, r.count())
+	for i := range p.ParamSlots {
+		p.ParamSlots[i].Cell, p.ParamSlots[i].Index = r.slot()
+		p.ParamSlots[i].Int = r.bool()
+	}
+	p.This.Cell, p.This.Index = r.slot()
+	p.NumLocals = r.uint()
+	p.NumCells = r.uint()
+	p.NumInts = r.uint()
+	p.MaxStack = r.uint()
+	p.Captures = make([]Capture, r.count())
+	for i := range p.Captures {
+		p.Captures[i].Cell, p.Captures[i].Index = r.slot()
+	}
+	p.HasTry = r.bool()
+
+	p.Code = make([]Instruction, r.count())
+	p.Lines = make([]core.Position, len(p.Code))
+	for i := range p.Code {
+		p.Code[i] = Instruction{Op: Opcode(r.byte()), A: int32(r.int()), B: int32(r.int()), C: int32(r.int())}
+		p.Lines[i] = r.position()
+	}
+
+	p.Selects = make([]*SelectTable, r.count())
+	for i := range p.Selects {
+		table := &SelectTable{Arms: make([]SelectArm, r.count())}
+		for j := range table.Arms {
+			table.Arms[j] = SelectArm{Send: r.bool(), Assign: r.bool(), Target: r.int()}
+		}
+		table.HasTimeout = r.bool()
+		table.TimeoutSource = r.string()
+		table.TimeoutTarget = r.int()
+		table.HasDefault = r.bool()
+		table.DefaultTarget = r.int()
+		p.Selects[i] = table
+	}
+	return p
+}
+
+func (r *reader) class() *Class {
+	decl := &core.ClassDeclarationNode{Name: r.string(), Pos: r.position()}
+	decl.Fields = make([]*core.FieldNode, r.count())
+	for i := range decl.Fields {
+		decl.Fields[i] = &core.FieldNode{Name: r.string(), Type: r.typeNode(), Pos: r.position()}
+	}
+	return &Class{Decl: decl, Defaults: r.ints(), Methods: r.ints()}
+}
@@ -0,0 +1,91 @@
+package up
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	core "github.com/KennethanCeyer/up/src/core"
+)
+
+const cachedSource = `func main() -> nil {
+    print("fresh")
+}
+`
+
+// reseal replaces the checksum of an encoded module, so a change to the
+// data is not reported as damage.
+func reseal(data []byte) []byte {
+	body := data[:len(data)-4]
+	sealed := append([]byte{}, body...)
+	var checksum [4]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(body))
+	return append(sealed, checksum[:]...)
+}
+
+func TestDecode(t *testing.T) {
+	source := []byte(cachedSource)
+	data := Encode(compileSource(t, source), source)
+	if _, err := Decode(data, source); err != nil {
+		t.Fatalf("Decode of a fresh module failed: %v", err)
+	}
+
+	otherVersion := append([]byte{}, data...)
+	otherVersion[len(magic)] = FormatVersion + 1
+	flipped := append([]byte{}, data...)
+	flipped[len(data)/2] ^= 0xff
+
+	tests := []struct {
+		name   string
+		data   []byte
+		source string
+		err    string
+	}{
+		{"format version", reseal(otherVersion), cachedSource, "format version"},
+		{"source hash", data, cachedSource + "\n", "out of date"},
+		{"checksum", flipped, cachedSource, "damaged"},
+		{"truncated", data[:len(data)/2], cachedSource, "damaged"},
+		{"truncated with checksum", reseal(data[:len(data)/2]), cachedSource, "truncated"},
+		{"empty", nil, cachedSource, "not a compiled up module"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module, err := Decode(tt.data, []byte(tt.source))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got module %v and error %v, want an error containing %q", module, err, tt.err)
+			}
+		})
+	}
+}
+
+// TestLoadCache checks that a cache written for another source is not
+// loaded, and that the module compiled again replaces it.
+func TestLoadCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "program.up")
+	stale := []byte(strings.Replace(cachedSource, "fresh", "stale", 1))
+	if err := WriteCache(path, compileSource(t, stale), stale); err != nil {
+		t.Fatal(err)
+	}
+
+	source := []byte(cachedSource)
+	if _, err := LoadCache(path, source); err == nil {
+		t.Fatal("LoadCache loaded a module compiled from another source")
+	}
+	if err := WriteCache(path, compileSource(t, source), source); err != nil {
+		t.Fatal(err)
+	}
+	module, err := LoadCache(path, source)
+	if err != nil {
+		t.Fatalf("LoadCache of the recompiled module failed: %v", err)
+	}
+	output := capture(t, func() {
+		if _, err := New(module, core.NewEnvironment()).Run(); err != nil {
+			t.Errorf("Uncaught error: %v", err)
+		}
+	})
+	if output != "fresh\n" {
+		t.Errorf("got output %q, want %q", output, "fresh\n")
+	}
+}
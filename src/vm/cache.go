@@ -0,0 +1,48 @@
+package up
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CachePath returns where the compiled module of a source file is kept:
+// next to the source, with the extension .upc.
+func CachePath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".upc"
+}
+
+// LoadCache loads the cached module of the source file at path. It fails
+// when there is no cache or it was not compiled from source.
+func LoadCache(path string, source []byte) (*Module, error) {
+	data, err := os.ReadFile(CachePath(path))
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data, source)
+}
+
+// WriteCache stores the module compiled from the source file at path. The
+// file is written under a temporary name and renamed, so a concurrent run
+// never reads a partial module.
+func WriteCache(path string, m *Module, source []byte) error {
+	cachePath := CachePath(path)
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), filepath.Base(cachePath)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(Encode(m, source)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,741 @@
+package up
+
+import (
+	"fmt"
+
+	core "github.com/KennethanCeyer/up/src/core"
+)
+
+type compiler struct {
+	module    *Module
+	constants map[core.Value]int
+	names     map[string]int
+	types     map[*core.TypeNode]int
+	globals   map[string]int
+	scopes    map[*core.FuncDeclarationNode]*scope
+	defaults  map[*core.FieldNode]*core.FuncDeclarationNode
+}
+
+// function is the state of the function being compiled.
+type function struct {
+	c        *compiler
+	parent   *function
+	scope    *scope
+	proto    *Prototype
+	slots    map[string]Slot
+	upvalues map[string]int
+	depth    int
+	// ints are the typed int locals kept in unboxed int slots, and intTop
+	// is the first int slot free for a temporary.
+	ints   map[string]int
+	intTop int
+}
+
+// Compile translates a parsed program into bytecode. Variables are
+// resolved to frame slots, cells and upvalues at compile time, so the VM
+// only looks names up in the global scope.
+func Compile(program *core.ProgramNode) (module *Module, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			message, ok := r.(string)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", message)
+		}
+	}()
+
+	c := &compiler{
+		module:    &Module{},
+		constants: map[core.Value]int{},
+		names:     map[string]int{},
+		types:     map[*core.TypeNode]int{},
+		globals:   map[string]int{},
+		scopes:    map[*core.FuncDeclarationNode]*scope{},
+		defaults:  map[*core.FieldNode]*core.FuncDeclarationNode{},
+	}
+	for _, class := range program.Classes {
+		for _, field := range class.Fields {
+			if field.Default != nil {
+				c.analyze(c.defaultFunction(field), nil)
+			}
+		}
+		for _, method := range class.Methods {
+			c.analyze(method, nil)
+		}
+	}
+	for _, decl := range program.Functions {
+		c.analyze(decl, nil)
+	}
+
+	for _, class := range program.Classes {
+		c.module.ProgramClasses = append(c.module.ProgramClasses, c.class(class, nil))
+	}
+	for _, decl := range program.Functions {
+		c.module.ProgramFunctions = append(c.module.ProgramFunctions, c.function(decl, nil))
+	}
+	return c.module, nil
+}
+
+func (c *compiler) constant(value core.Value) int32 {
+	if index, ok := c.constants[value]; ok {
+		return int32(index)
+	}
+	index := len(c.module.Constants)
+	c.module.Constants = append(c.module.Constants, value)
+	c.constants[value] = index
+	return int32(index)
+}
+
+func (c *compiler) name(name string) int32 {
+	if index, ok := c.names[name]; ok {
+		return int32(index)
+	}
+	index := len(c.module.Names)
+	c.module.Names = append(c.module.Names, name)
+	c.names[name] = index
+	return int32(index)
+}
+
+func (c *compiler) typeIndex(t *core.TypeNode) int {
+	if index, ok := c.types[t]; ok {
+		return index
+	}
+	index := len(c.module.Types)
+	c.module.Types = append(c.module.Types, t)
+	c.types[t] = index
+	return index
+}
+
+func (c *compiler) global(name string) int32 {
+	if index, ok := c.globals[name]; ok {
+		return int32(index)
+	}
+	index := len(c.module.Globals)
+	c.module.Globals = append(c.module.Globals, name)
+	c.globals[name] = index
+	return int32(index)
+}
+
+// class compiles a class declaration. Only the name and the fields of the
+// declaration are kept; defaults and methods become prototypes.
+func (c *compiler) class(decl *core.ClassDeclarationNode, parent *function) int {
+	class := &Class{Decl: &core.ClassDeclarationNode{Name: decl.Name, Pos: decl.Pos}}
+	for _, field := range decl.Fields {
+		class.Decl.Fields = append(class.Decl.Fields, &core.FieldNode{Name: field.Name, Type: field.Type, Pos: field.Pos})
+		if field.Default == nil {
+			class.Defaults = append(class.Defaults, -1)
+			continue
+		}
+		class.Defaults = append(class.Defaults, c.function(c.defaultFunction(field), parent))
+	}
+	for _, method := range decl.Methods {
+		class.Methods = append(class.Methods, c.function(method, parent))
+	}
+	c.module.Classes = append(c.module.Classes, class)
+	return len(c.module.Classes) - 1
+}
+
+// function compiles a function declaration or literal and returns the
+// index of its prototype.
+func (c *compiler) function(decl *core.FuncDeclarationNode, parent *function) int {
+	s := c.scopes[decl]
+	proto := &Prototype{
+		Name:       decl.Name,
+		Signature:  decl.Signature(),
+		ReturnType: c.typeIndex(decl.ReturnType),
+		Pos:        decl.Pos,
+		This:       Slot{Index: -1},
+	}
+	if decl.Receiver != nil {
+		proto.Receiver = decl.Receiver.Name
+	}
+	index := len(c.module.Prototypes)
+	c.module.Prototypes = append(c.module.Prototypes, proto)
+
+	f := &function{c: c, parent: parent, scope: s, proto: proto, slots: map[string]Slot{}, upvalues: map[string]int{}}
+	for _, name := range s.locals {
+		if s.captured[name] {
+			f.slots[name] = Slot{Cell: true, Index: proto.NumCells}
+			proto.NumCells++
+		} else {
+			f.slots[name] = Slot{Index: proto.NumLocals}
+			proto.NumLocals++
+		}
+	}
+	// the typed int locals the tree-walker specializes are unboxed here too
+	f.ints = core.IntSlots(decl)
+	proto.NumInts = len(f.ints)
+	f.intTop = len(f.ints)
+	for _, param := range decl.Parameters {
+		slot := f.slots[param.Name]
+		if index, ok := f.ints[param.Name]; ok {
+			slot = Slot{Int: true, Index: index}
+		}
+		proto.Params = append(proto.Params, param.Name)
+		proto.ParamTypes = append(proto.ParamTypes, c.typeIndex(param.Type))
+		proto.ParamSlots = append(proto.ParamSlots, slot)
+	}
+	if decl.Receiver != nil {
+		proto.This = f.slots["this"]
+	}
+
+	f.block(decl.Body)
+	f.emit(OpEnd, 0, 0, 0, decl.Pos)
+	return index
+}
+
+func (f *function) emit(op Opcode, a, b, c int32, pos core.Position) int {
+	in := Instruction{Op: op, A: a, B: b, C: c}
+	f.proto.Code = append(f.proto.Code, in)
+	f.proto.Lines = append(f.proto.Lines, pos)
+	f.depth += stackEffect(in)
+	if f.depth > f.proto.MaxStack {
+		f.proto.MaxStack = f.depth
+	}
+	return len(f.proto.Code) - 1
+}
+
+// patch points the jump at pc to the next instruction.
+func (f *function) patch(pc int) {
+	f.proto.Code[pc].A = int32(len(f.proto.Code))
+}
+
+func (f *function) here() int {
+	return len(f.proto.Code)
+}
+
+// stackEffect is how much an instruction grows the operand stack.
+// OpSelect is accounted for by the compiler itself.
+func stackEffect(in Instruction) int {
+	switch in.Op {
+	case OpConst, OpNil, OpLoadLocal, OpLoadCell, OpLoadUpvalue, OpLoadGlobal, OpClosure, OpClass, OpLoadInt:
+		return 1
+	case OpStoreInt, OpPop, OpAssignLocal, OpAssignCell, OpAssignUpvalue, OpDeclareLocal, OpDeclareCell,
+		OpAdd, OpSubtract, OpMultiply, OpDivide, OpModulo, OpEqual, OpNotEqual, OpLess, OpGreater, OpLessEqual, OpGreaterEqual,
+		OpJumpIfFalse, OpJumpIfTrue, OpIndex, OpDefineMethod, OpThrow:
+		return -1
+	case OpReturn:
+		if in.A == 1 {
+			return 0
+		}
+		return -1
+	case OpDup:
+		return int(in.A)
+	case OpList:
+		return 1 - int(in.A)
+	case OpMap:
+		return 1 - 2*int(in.A)
+	case OpSetIndex:
+		return -3
+	case OpSlice, OpSetField, OpSend:
+		return -2
+	case OpCall, OpCallMethod:
+		return -int(in.A)
+	case OpSpawn:
+		return -int(in.A) - 1
+	case OpNext:
+		return int(in.B)
+	}
+	return 0
+}
+
+func (f *function) block(body []core.Node) {
+	for _, stmt := range body {
+		f.statement(stmt)
+	}
+}
+
+// variable describes how the function reaches a name.
+type variable struct {
+	op    Opcode
+	index int32
+}
+
+// resolve finds name among the locals, then the variables of enclosing
+// functions, and otherwise in the global scope.
+func (f *function) resolve(name string) variable {
+	if slot, ok := f.slots[name]; ok {
+		if slot.Cell {
+			return variable{OpLoadCell, int32(slot.Index)}
+		}
+		return variable{OpLoadLocal, int32(slot.Index)}
+	}
+	if index, ok := f.upvalue(name); ok {
+		return variable{OpLoadUpvalue, int32(index)}
+	}
+	return variable{OpLoadGlobal, f.c.global(name)}
+}
+
+func (f *function) upvalue(name string) (int, bool) {
+	if index, ok := f.upvalues[name]; ok {
+		return index, true
+	}
+	if f.parent == nil {
+		return 0, false
+	}
+	var capture Capture
+	if slot, ok := f.parent.slots[name]; ok {
+		if !slot.Cell {
+			panic(fmt.Sprintf("Variable %s is not captured", name))
+		}
+		capture = Capture{Cell: true, Index: slot.Index}
+	} else if index, ok := f.parent.upvalue(name); ok {
+		capture = Capture{Index: index}
+	} else {
+		return 0, false
+	}
+	index := len(f.proto.Captures)
+	f.proto.Captures = append(f.proto.Captures, capture)
+	f.upvalues[name] = index
+	return index, true
+}
+
+// load pushes the value of name. called selects the error message for
+// names that are not found.
+func (f *function) load(name string, called bool, pos core.Position) {
+	if index, ok := f.ints[name]; ok {
+		f.emit(OpLoadInt, int32(index), 0, 0, pos)
+		return
+	}
+	v := f.resolve(name)
+	var flag int32
+	if called {
+		flag = 1
+	}
+	f.emit(v.op, v.index, f.c.name(name), flag, pos)
+}
+
+// set stores into a local like Environment.Set: a variable declared with
+// a type keeps it, and the value is checked against it.
+func (f *function) set(name string, pos core.Position) {
+	slot := f.slots[name]
+	if slot.Cell {
+		f.emit(OpAssignCell, int32(slot.Index), f.c.name(name), 0, pos)
+	} else {
+		f.emit(OpAssignLocal, int32(slot.Index), f.c.name(name), 0, pos)
+	}
+}
+
+func (f *function) statement(node core.Node) {
+	depth := f.depth
+	defer func() {
+		f.depth = depth
+	}()
+
+	switch n := node.(type) {
+	case *core.AssignmentNode:
+		if index, ok := f.ints[n.VarName]; ok {
+			f.storeInt(n.Value, int32(index), n.VarName, n.Pos)
+			return
+		}
+		f.expression(n.Value)
+		name := f.c.name(n.VarName)
+		if n.Type != nil {
+			slot := f.slots[n.VarName]
+			op := OpDeclareLocal
+			if slot.Cell {
+				op = OpDeclareCell
+			}
+			f.emit(op, int32(slot.Index), name, int32(f.c.typeIndex(n.Type)), n.Pos)
+			return
+		}
+		switch v := f.resolve(n.VarName); v.op {
+		case OpLoadLocal:
+			f.emit(OpAssignLocal, v.index, name, 0, n.Pos)
+		case OpLoadCell:
+			f.emit(OpAssignCell, v.index, name, 0, n.Pos)
+		case OpLoadUpvalue:
+			f.emit(OpAssignUpvalue, v.index, name, 0, n.Pos)
+		default:
+			panic(fmt.Sprintf("Cannot assign to global %s at %s", n.VarName, n.Pos))
+		}
+	case *core.IndexAssignmentNode:
+		f.expression(n.Object)
+		f.expression(n.Index)
+		source := f.c.name(n.Index.String())
+		if n.Op != "" {
+			// the object and the index stay on the stack for the store
+			f.emit(OpDup, 2, 0, 0, n.Pos)
+			f.emit(OpIndex, 0, source, 0, n.Pos)
+		}
+		f.assignedValue(n.Op, n.Value, n.Pos)
+		f.emit(OpSetIndex, 0, source, 0, n.Pos)
+	case *core.FieldAssignmentNode:
+		f.expression(n.Object)
+		field, source := f.c.name(n.Field), f.c.name(n.Object.String())
+		if n.Op != "" {
+			f.emit(OpDup, 1, 0, 0, n.Pos)
+			f.emit(OpGetField, field, source, 0, n.Pos)
+		}
+		f.assignedValue(n.Op, n.Value, n.Pos)
+		f.emit(OpSetField, field, source, 0, n.Pos)
+	case *core.IfNode:
+		f.expression(n.Condition)
+		jumpElse := f.emit(OpJumpIfFalse, 0, 0, 0, n.Pos)
+		f.block(n.Then)
+		if len(n.Else) == 0 {
+			f.patch(jumpElse)
+			return
+		}
+		jumpEnd := f.emit(OpJump, 0, 0, 0, n.Pos)
+		f.patch(jumpElse)
+		f.block(n.Else)
+		f.patch(jumpEnd)
+	case *core.ForLoopNode:
+		f.forLoop(n)
+	case *core.ReturnNode:
+		if n.Value == nil {
+			f.emit(OpReturn, 1, 0, 0, n.Pos)
+			return
+		}
+		f.expression(n.Value)
+		f.emit(OpReturn, 0, 0, 0, n.Pos)
+	case *core.SendNode:
+		f.expression(n.Stream)
+		f.expression(n.Value)
+		f.emit(OpSend, 0, f.c.name(n.Stream.String()), 0, n.Pos)
+	case *core.SelectNode:
+		f.selectStatement(n)
+	case *core.ThrowNode:
+		f.expression(n.Value)
+		f.emit(OpThrow, 0, 0, 0, n.Pos)
+	case *core.TryNode:
+		f.proto.HasTry = true
+		try := f.emit(OpTry, 0, 0, 0, n.Pos)
+		f.block(n.Body)
+		f.emit(OpEndTry, 0, 0, 0, n.Pos)
+		jumpEnd := f.emit(OpJump, 0, 0, 0, n.Pos)
+		f.patch(try)
+		// the caught error is on the stack when the catch block starts
+		f.depth = depth + 1
+		if f.depth > f.proto.MaxStack {
+			f.proto.MaxStack = f.depth
+		}
+		if n.CatchVar != "" {
+			f.set(n.CatchVar, n.Pos)
+		} else {
+			f.emit(OpPop, 0, 0, 0, n.Pos)
+		}
+		f.block(n.Catch)
+		f.patch(jumpEnd)
+	case *core.SpawnNode:
+		call := n.Call
+		f.callee(call)
+		for _, arg := range call.Arguments {
+			f.expression(arg)
+		}
+		f.emit(OpSpawn, int32(len(call.Arguments)), f.c.name(call.String()), f.c.name(call.CalleeString()), call.Pos)
+	case *core.FuncDeclarationNode:
+		f.emit(OpClosure, int32(f.c.function(n, f)), 0, 0, n.Pos)
+		switch {
+		case n.Receiver != nil:
+			f.emit(OpDefineMethod, f.c.name(n.Receiver.Name), f.c.name(n.Name), 0, n.Pos)
+		case n.Name != "":
+			f.set(n.Name, n.Pos)
+		default:
+			f.emit(OpPop, 0, 0, 0, n.Pos)
+		}
+	case *core.ClassDeclarationNode:
+		f.emit(OpClass, int32(f.c.class(n, f)), 0, 0, n.Pos)
+		f.set(n.Name, n.Pos)
+	default:
+		f.expression(node)
+		f.emit(OpPop, 0, 0, 0, core.Position{})
+	}
+}
+
+func (f *function) forLoop(n *core.ForLoopNode) {
+	count := int32(1)
+	if n.Range != nil {
+		f.expression(n.Range)
+		f.emit(OpRange, 0, 0, 0, n.Pos)
+	} else {
+		f.expression(n.Iterable)
+		twoVariables := int32(0)
+		if n.ValueVariable != "" {
+			twoVariables, count = 1, 2
+		}
+		f.emit(OpIterate, twoVariables, 0, 0, n.Pos)
+	}
+
+	depth := f.depth
+	loop := f.here()
+	next := f.emit(OpNext, 0, count, 0, n.Pos)
+	if count == 2 {
+		f.set(n.ValueVariable, n.Pos)
+	}
+	f.set(n.Variable, n.Pos)
+	f.block(n.Body)
+	f.emit(OpJump, int32(loop), 0, 0, n.Pos)
+	f.patch(next)
+	f.depth = depth
+	f.emit(OpPop, 0, 0, 0, n.Pos)
+}
+
+func (f *function) selectStatement(n *core.SelectNode) {
+	table := &SelectTable{HasDefault: n.HasDefault}
+	operands := 0
+	for _, c := range n.Cases {
+		var stream core.Node
+		arm := SelectArm{}
+		switch comm := c.Comm.(type) {
+		case *core.SendNode:
+			stream = comm.Stream
+			arm.Send = true
+		case *core.ReceiveNode:
+			stream = comm.Stream
+		case *core.AssignmentNode:
+			stream = comm.Value.(*core.ReceiveNode).Stream
+			arm.Assign = true
+		}
+		f.expression(stream)
+		f.emit(OpSelectStream, f.c.name(stream.String()), 0, 0, n.Pos)
+		operands++
+		if arm.Send {
+			f.expression(c.Comm.(*core.SendNode).Value)
+			f.emit(OpSelectSend, 0, 0, 0, n.Pos)
+			operands++
+		}
+		table.Arms = append(table.Arms, arm)
+	}
+	if n.Timeout != nil {
+		f.expression(n.Timeout)
+		table.HasTimeout = true
+		table.TimeoutSource = n.Timeout.String()
+		operands++
+	}
+
+	f.proto.Selects = append(f.proto.Selects, table)
+	f.emit(OpSelect, int32(len(f.proto.Selects)-1), 0, 0, n.Pos)
+	f.depth -= operands
+	depth := f.depth
+
+	var jumps []int
+	for i, c := range n.Cases {
+		table.Arms[i].Target = f.here()
+		if table.Arms[i].Assign {
+			f.depth++
+			if f.depth > f.proto.MaxStack {
+				f.proto.MaxStack = f.depth
+			}
+			f.set(c.Comm.(*core.AssignmentNode).VarName, n.Pos)
+		}
+		f.block(c.Body)
+		jumps = append(jumps, f.emit(OpJump, 0, 0, 0, n.Pos))
+		f.depth = depth
+	}
+	if n.Timeout != nil {
+		table.TimeoutTarget = f.here()
+		f.block(n.TimeoutBody)
+		jumps = append(jumps, f.emit(OpJump, 0, 0, 0, n.Pos))
+	}
+	if n.HasDefault {
+		table.DefaultTarget = f.here()
+		f.block(n.Default)
+	}
+	for _, jump := range jumps {
+		f.patch(jump)
+	}
+}
+
+// callee pushes the function of a call: an expression, or a name whose
+// absence is reported as a missing function.
+func (f *function) callee(n *core.FunctionCallNode) {
+	if n.Callee != nil {
+		f.expression(n.Callee)
+		return
+	}
+	f.load(n.FunctionName, true, n.Pos)
+}
+
+func (f *function) expression(node core.Node) {
+	switch n := node.(type) {
+	case *core.IntNode:
+		f.emit(OpConst, f.c.constant(core.Int(n.Value)), 0, 0, core.Position{})
+	case *core.FloatNode:
+		f.emit(OpConst, f.c.constant(core.Float(n.Value)), 0, 0, core.Position{})
+	case *core.StringNode:
+		f.emit(OpConst, f.c.constant(core.String(n.Value)), 0, 0, core.Position{})
+	case *core.BoolNode:
+		f.emit(OpConst, f.c.constant(core.Bool(n.Value)), 0, 0, core.Position{})
+	case *core.NilNode:
+		f.emit(OpNil, 0, 0, 0, core.Position{})
+	case *core.IdentifierNode:
+		f.load(n.Name, false, n.Pos)
+	case *core.BinOpNode:
+		// the operands stay unboxed, so only the result is boxed.
+		if len(f.ints) > 0 && f.staticInt(n) {
+			temp := f.intTemp()
+			f.intOperation(n, temp)
+			f.intTop = int(temp)
+			f.emit(OpLoadInt, temp, 0, 0, n.Pos)
+			return
+		}
+		f.binary(n)
+	case *core.UnaryOpNode:
+		f.expression(n.Operand)
+		switch n.Op {
+		case "!":
+			f.emit(OpNot, 0, 0, 0, n.Pos)
+		case "-":
+			f.emit(OpNegate, 0, 0, 0, n.Pos)
+		default:
+			panic(fmt.Sprintf("Unknown operator: %s at %s", n.Op, n.Pos))
+		}
+	case *core.FunctionCallNode:
+		f.callee(n)
+		for _, arg := range n.Arguments {
+			f.expression(arg)
+		}
+		f.emit(OpCall, int32(len(n.Arguments)), f.c.name(n.CalleeString()), 0, n.Pos)
+	case *core.MethodCallNode:
+		f.expression(n.Receiver)
+		for _, arg := range n.Arguments {
+			f.expression(arg)
+		}
+		f.emit(OpCallMethod, int32(len(n.Arguments)), f.c.name(n.Method), 0, n.Pos)
+	case *core.FieldAccessNode:
+		f.expression(n.Object)
+		f.emit(OpGetField, f.c.name(n.Field), f.c.name(n.Object.String()), 0, n.Pos)
+	case *core.IndexNode:
+		f.expression(n.Object)
+		f.expression(n.Index)
+		f.emit(OpIndex, 0, f.c.name(n.Index.String()), 0, n.Pos)
+	case *core.SliceNode:
+		f.expression(n.Object)
+		low, high := int32(-1), int32(-1)
+		if n.Low != nil {
+			f.expression(n.Low)
+			low = f.c.name(n.Low.String())
+		} else {
+			f.emit(OpNil, 0, 0, 0, n.Pos)
+		}
+		if n.High != nil {
+			f.expression(n.High)
+			high = f.c.name(n.High.String())
+		} else {
+			f.emit(OpNil, 0, 0, 0, n.Pos)
+		}
+		f.emit(OpSlice, low, high, 0, n.Pos)
+	case *core.ListNode:
+		for _, element := range n.Elements {
+			f.expression(element)
+		}
+		f.emit(OpList, int32(len(n.Elements)), 0, 0, n.Pos)
+	case *core.MapNode:
+		for i, key := range n.Keys {
+			f.expression(key)
+			f.expression(n.Values[i])
+		}
+		f.emit(OpMap, int32(len(n.Keys)), 0, 0, n.Pos)
+	case *core.ReceiveNode:
+		f.expression(n.Stream)
+		f.emit(OpReceive, 0, f.c.name(n.Stream.String()), 0, n.Pos)
+	case *core.FuncDeclarationNode:
+		f.emit(OpClosure, int32(f.c.function(n, f)), 0, 0, n.Pos)
+	default:
+		panic(fmt.Sprintf("Cannot compile %s as an expression", node.String()))
+	}
+}
+
+// assignedValue pushes the value stored by an index or field assignment.
+// A compound assignment combines it with the current value of the target,
+// which is on the stack.
+func (f *function) assignedValue(op string, value core.Node, pos core.Position) {
+	f.expression(value)
+	if op != "" {
+		f.emit(binaryOperators[op], 0, 0, 0, pos)
+	}
+}
+
+func (f *function) binary(n *core.BinOpNode) {
+	switch n.Op {
+	case "&&", "||":
+		// only evaluate the right side when the left one does not decide
+		f.expression(n.Left)
+		jump, decided := OpJumpIfFalse, false
+		if n.Op == "||" {
+			jump, decided = OpJumpIfTrue, true
+		}
+		short := f.emit(jump, 0, 0, 0, n.Pos)
+		f.expression(n.Right)
+		f.emit(OpBool, 0, 0, 0, n.Pos)
+		end := f.emit(OpJump, 0, 0, 0, n.Pos)
+		f.patch(short)
+		f.depth--
+		f.emit(OpConst, f.c.constant(core.Bool(decided)), 0, 0, n.Pos)
+		f.patch(end)
+		return
+	}
+
+	op, ok := binaryOperators[n.Op]
+	if !ok {
+		panic(fmt.Sprintf("Unknown operator: %s at %s", n.Op, n.Pos))
+	}
+	f.expression(n.Left)
+	f.expression(n.Right)
+	f.emit(op, 0, 0, 0, n.Pos)
+}
+
+// staticInt reports whether node always evaluates to an int: literals,
+// int slots and the arithmetic between them.
+func (f *function) staticInt(node core.Node) bool {
+	switch n := node.(type) {
+	case *core.IntNode:
+		return true
+	case *core.IdentifierNode:
+		_, ok := f.ints[n.Name]
+		return ok
+	case *core.BinOpNode:
+		_, ok := intOperators[n.Op]
+		return ok && f.staticInt(n.Left) && f.staticInt(n.Right)
+	}
+	return false
+}
+
+// storeInt stores node into the int slot dst. Static int expressions are
+// computed on int slots; any other value is checked to be an int.
+func (f *function) storeInt(node core.Node, dst int32, name string, pos core.Position) {
+	switch n := node.(type) {
+	case *core.IntNode:
+		f.emit(OpIntConst, dst, f.c.constant(core.Int(n.Value)), 0, pos)
+		return
+	case *core.BinOpNode:
+		if f.staticInt(n) {
+			f.intOperation(n, dst)
+			return
+		}
+	}
+	f.expression(node)
+	f.emit(OpStoreInt, dst, f.c.name(name), 0, pos)
+}
+
+// intOperation computes static int arithmetic into the int slot dst.
+func (f *function) intOperation(n *core.BinOpNode, dst int32) {
+	top := f.intTop
+	left := f.intOperand(n.Left)
+	right := f.intOperand(n.Right)
+	f.intTop = top
+	f.emit(intOperators[n.Op], dst, left, right, n.Pos)
+}
+
+// intOperand returns the int slot holding a static int expression, which
+// is a temporary unless the expression is an int local.
+func (f *function) intOperand(node core.Node) int32 {
+	if n, ok := node.(*core.IdentifierNode); ok {
+		return int32(f.ints[n.Name])
+	}
+	temp := f.intTemp()
+	f.storeInt(node, temp, "", core.Position{})
+	return temp
+}
+
+func (f *function) intTemp() int32 {
+	temp := f.intTop
+	f.intTop++
+	if f.intTop > f.proto.NumInts {
+		f.proto.NumInts = f.intTop
+	}
+	return int32(temp)
+}
@@ -0,0 +1,54 @@
+package up
+
+import (
+	"testing"
+
+	core "github.com/KennethanCeyer/up/src/core"
+)
+
+// interleaved prints from several threads, so its output shows the order
+// the threads ran in.
+const interleaved = `func worker(id: int, done: stream) -> nil {
+    for i in range(5) {
+        print(id, ":", i)
+    }
+    done <- true
+}
+
+func main() -> nil {
+    done = stream()
+    for id in range(4) {
+        up worker(id, done)
+    }
+    for id in range(4) {
+        <-done
+    }
+}
+`
+
+func runSeeded(t *testing.T, module *Module, seed int64) string {
+	return capture(t, func() {
+		env := core.NewEnvironment()
+		env.Threads().SetDeterministic(seed)
+		if _, err := New(module, env).Run(); err != nil {
+			t.Errorf("Uncaught error: %v", err)
+		}
+	})
+}
+
+// TestDeterministicReplay checks that a seed replays the same interleaving
+// on the VM and that other seeds pick other ones.
+func TestDeterministicReplay(t *testing.T) {
+	module := compileSource(t, []byte(interleaved))
+	first := runSeeded(t, module, 1)
+	if again := runSeeded(t, module, 1); again != first {
+		t.Fatalf("seed 1 did not replay:\n%s\nthen\n%s", first, again)
+	}
+	seen := map[string]bool{first: true}
+	for seed := int64(2); seed <= 10; seed++ {
+		seen[runSeeded(t, module, seed)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("seeds 1 to 10 all ran the threads in the same order")
+	}
+}
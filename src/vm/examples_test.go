@@ -0,0 +1,71 @@
+package up
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	core "github.com/KennethanCeyer/up/src/core"
+)
+
+// TestExamples runs every example on the tree-walker, on the VM and on the
+// VM after its module went through the bytecode cache format, and checks
+// that all three print the same. Threads run with a fixed seed, so the
+// examples using them print in the same order every time.
+func TestExamples(t *testing.T) {
+	files, err := filepath.Glob("../../examples/*.up")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := core.Lexer(string(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			program, err := core.Parse(tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree := runExample(t, func(env *core.Environment) (core.Value, error) {
+				return core.Run(program, env)
+			})
+
+			module := compileSource(t, source)
+			compiled := runExample(t, func(env *core.Environment) (core.Value, error) {
+				return New(module, env).Run()
+			})
+			if compiled != tree {
+				t.Errorf("the VM printed\n%s\nthe tree-walker\n%s", compiled, tree)
+			}
+
+			loaded, err := Decode(Encode(module, source), source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reloaded := runExample(t, func(env *core.Environment) (core.Value, error) {
+				return New(loaded, env).Run()
+			})
+			if reloaded != tree {
+				t.Errorf("the loaded module printed\n%s\nthe tree-walker\n%s", reloaded, tree)
+			}
+		})
+	}
+}
+
+// runExample runs a program with a fixed seed and returns what it printed,
+// followed by its uncaught error if any, as the driver prints it.
+func runExample(t *testing.T, run func(env *core.Environment) (core.Value, error)) string {
+	return capture(t, func() {
+		env := core.NewEnvironment()
+		env.Threads().SetDeterministic(1)
+		if _, err := run(env); err != nil {
+			fmt.Println("Uncaught error:", err)
+		}
+	})
+}
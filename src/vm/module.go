@@ -0,0 +1,127 @@
+package up
+
+import (
+	"fmt"
+	"strings"
+
+	core "github.com/KennethanCeyer/up/src/core"
+)
+
+// Instruction is a single VM instruction. The meaning of the operands
+// depends on the opcode; unused operands are zero.
+type Instruction struct {
+	Op      Opcode
+	A, B, C int32
+}
+
+// Slot is where a variable of a function lives: a plain local of the
+// frame, a cell when nested functions capture it, or an unboxed int slot
+// for a typed int local.
+type Slot struct {
+	Cell  bool
+	Int   bool
+	Index int
+}
+
+// Capture says where a closure finds a captured variable when it is
+// created: in a cell of the enclosing frame, or in an upvalue of the
+// enclosing function.
+type Capture struct {
+	Cell  bool
+	Index int
+}
+
+// SelectArm is a case of a select statement.
+type SelectArm struct {
+	Send bool
+	// Assign is set for `case x = <-s`, whose body starts by storing the
+	// received value.
+	Assign bool
+	Target int
+}
+
+// SelectTable describes a select statement: its arms and where each
+// of them continues.
+type SelectTable struct {
+	Arms          []SelectArm
+	HasTimeout    bool
+	TimeoutSource string
+	TimeoutTarget int
+	HasDefault    bool
+	DefaultTarget int
+}
+
+// Prototype is a compiled function. Closures created from it share the
+// code and differ in their captured variables.
+type Prototype struct {
+	Name      string
+	Signature string
+	// Receiver is the receiver type name of a method.
+	Receiver   string
+	Params     []string
+	ParamTypes []int
+	ReturnType int
+	Pos        core.Position
+
+	ParamSlots []Slot
+	// This is the slot of the receiver of a method, with Index -1 for
+	// other functions.
+	This      Slot
+	NumLocals int
+	NumCells  int
+	// NumInts counts the int slots: typed int locals, then temporaries.
+	NumInts  int
+	MaxStack int
+	Captures []Capture
+	HasTry   bool
+
+	Code []Instruction
+	// Lines holds the source position of every instruction.
+	Lines   []core.Position
+	Selects []*SelectTable
+}
+
+func (p *Prototype) DisplayName() string {
+	if p.Name == "" {
+		return "<anonymous>"
+	}
+	return p.Name
+}
+
+// Class is a compiled class declaration. Decl keeps the name and the
+// fields; field defaults and methods are compiled to prototypes.
+type Class struct {
+	Decl *core.ClassDeclarationNode
+	// Defaults holds the prototype computing the default of each field,
+	// or -1 for fields without one.
+	Defaults []int
+	Methods  []int
+}
+
+// Module is a compiled program.
+type Module struct {
+	Constants  []core.Value
+	Names      []string
+	Types      []*core.TypeNode
+	Prototypes []*Prototype
+	Classes    []*Class
+	// Globals are the names read from the global scope.
+	Globals []string
+
+	// Program lists the top-level classes and functions in the order they
+	// are declared.
+	ProgramClasses   []int
+	ProgramFunctions []int
+}
+
+// Disassemble returns a readable listing of the compiled code.
+func (m *Module) Disassemble() string {
+	var sb strings.Builder
+	for i, proto := range m.Prototypes {
+		fmt.Fprintf(&sb, "function #%d %s (locals %d, cells %d, ints %d, stack %d)\n", i, proto.Signature, proto.NumLocals, proto.NumCells, proto.NumInts, proto.MaxStack)
+		for pc, in := range proto.Code {
+			fmt.Fprintf(&sb, "  %4d %-8s %-14s %d %d %d\n", pc, proto.Lines[pc], in.Op, in.A, in.B, in.C)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,195 @@
+package up
+
+import "fmt"
+
+// Opcode is the operation of an instruction. The comment of every opcode
+// lists its operands and what it does to the operand stack.
+type Opcode uint8
+
+const (
+	OpConst Opcode = iota // A: constant          -> value
+	OpNil                 //                      -> nil
+	OpPop                 // value                ->
+	OpDup                 // A: count             values... -> values... values...
+
+	OpLoadLocal   // A: slot, B: name, C: 1 when called   -> value
+	OpLoadCell    // A: cell, B: name, C: 1 when called   -> value
+	OpLoadUpvalue // A: upvalue, B: name, C: 1 when called -> value
+	OpLoadGlobal  // A: global, C: 1 when called          -> value
+
+	// Assign stores into a variable and checks its declared type, Declare
+	// stores and declares a type.
+	OpAssignLocal   // A: slot, B: name       value ->
+	OpAssignCell    // A: cell, B: name       value ->
+	OpAssignUpvalue // A: upvalue, B: name    value ->
+	OpDeclareLocal  // A: slot, B: name, C: type   value ->
+	OpDeclareCell   // A: cell, B: name, C: type   value ->
+
+	OpAdd          // left right -> value
+	OpSubtract     // left right -> value
+	OpMultiply     // left right -> value
+	OpDivide       // left right -> value
+	OpModulo       // left right -> value
+	OpEqual        // left right -> bool
+	OpNotEqual     // left right -> bool
+	OpLess         // left right -> bool
+	OpGreater      // left right -> bool
+	OpLessEqual    // left right -> bool
+	OpGreaterEqual // left right -> bool
+	OpNot          // value -> bool
+	OpNegate       // value -> value
+	OpBool         // value -> bool
+
+	OpJump        // A: target
+	OpJumpIfFalse // A: target   value ->
+	OpJumpIfTrue  // A: target   value ->
+
+	OpList     // A: count        elements... -> list
+	OpMap      // A: count        key value... -> map
+	OpIndex    // B: index source        object index -> value
+	OpSetIndex // B: index source        object index value ->
+	OpSlice    // A, B: low and high source or -1 when omitted   object low high -> value
+	OpGetField // A: field, B: object source   object -> value
+	OpSetField // A: field, B: object source   object value ->
+
+	OpCall         // A: argc, B: callee source   callee args... -> value
+	OpCallMethod   // A: argc, B: method          receiver args... -> value
+	OpSpawn        // A: argc, B: thread name, C: callee source   callee args... ->
+	OpReturn       // A: 1 for a bare return      value ->
+	OpEnd          // end of the function body
+	OpClosure      // A: prototype                -> function
+	OpDefineMethod // A: type name, B: method     function ->
+	OpClass        // A: class                    -> type
+
+	OpSend    // B: stream source   stream value ->
+	OpReceive // B: stream source   stream -> value
+	OpThrow   // value ->
+	OpTry     // A: catch target; the error is pushed when the catch is entered
+	OpEndTry
+
+	OpRange   // count -> iterator
+	OpIterate // A: 1 with two loop variables   iterable -> iterator
+	OpNext    // A: exit target, B: count       iterator -> iterator values...
+
+	OpSelectStream // A: stream source   stream -> stream
+	OpSelectSend   //                    stream value -> stream value
+	OpSelect       // A: select table    streams, sent values and timeout ->
+
+	// Typed int locals and the arithmetic between them use unboxed int
+	// slots of the frame, which hold temporaries as well.
+	OpLoadInt  // A: int slot              -> value
+	OpStoreInt // A: int slot, B: name     value ->
+	OpIntConst // A: int slot, B: constant
+	OpIntAdd   // A: int slot, B, C: operand int slots
+	OpIntSubtract
+	OpIntMultiply
+	OpIntDivide
+	OpIntModulo
+)
+
+var opcodeNames = [...]string{
+	OpConst:         "CONST",
+	OpNil:           "NIL",
+	OpPop:           "POP",
+	OpDup:           "DUP",
+	OpLoadLocal:     "LOAD_LOCAL",
+	OpLoadCell:      "LOAD_CELL",
+	OpLoadUpvalue:   "LOAD_UPVALUE",
+	OpLoadGlobal:    "LOAD_GLOBAL",
+	OpAssignLocal:   "ASSIGN_LOCAL",
+	OpAssignCell:    "ASSIGN_CELL",
+	OpAssignUpvalue: "ASSIGN_UPVALUE",
+	OpDeclareLocal:  "DECLARE_LOCAL",
+	OpDeclareCell:   "DECLARE_CELL",
+	OpAdd:           "ADD",
+	OpSubtract:      "SUBTRACT",
+	OpMultiply:      "MULTIPLY",
+	OpDivide:        "DIVIDE",
+	OpModulo:        "MODULO",
+	OpEqual:         "EQUAL",
+	OpNotEqual:      "NOT_EQUAL",
+	OpLess:          "LESS",
+	OpGreater:       "GREATER",
+	OpLessEqual:     "LESS_EQUAL",
+	OpGreaterEqual:  "GREATER_EQUAL",
+	OpNot:           "NOT",
+	OpNegate:        "NEGATE",
+	OpBool:          "BOOL",
+	OpJump:          "JUMP",
+	OpJumpIfFalse:   "JUMP_IF_FALSE",
+	OpJumpIfTrue:    "JUMP_IF_TRUE",
+	OpList:          "LIST",
+	OpMap:           "MAP",
+	OpIndex:         "INDEX",
+	OpSetIndex:      "SET_INDEX",
+	OpSlice:         "SLICE",
+	OpGetField:      "GET_FIELD",
+	OpSetField:      "SET_FIELD",
+	OpCall:          "CALL",
+	OpCallMethod:    "CALL_METHOD",
+	OpSpawn:         "SPAWN",
+	OpReturn:        "RETURN",
+	OpEnd:           "END",
+	OpClosure:       "CLOSURE",
+	OpDefineMethod:  "DEFINE_METHOD",
+	OpClass:         "CLASS",
+	OpSend:          "SEND",
+	OpReceive:       "RECEIVE",
+	OpThrow:         "THROW",
+	OpTry:           "TRY",
+	OpEndTry:        "END_TRY",
+	OpRange:         "RANGE",
+	OpIterate:       "ITERATE",
+	OpNext:          "NEXT",
+	OpSelectStream:  "SELECT_STREAM",
+	OpSelectSend:    "SELECT_SEND",
+	OpSelect:        "SELECT",
+	OpLoadInt:       "LOAD_INT",
+	OpStoreInt:      "STORE_INT",
+	OpIntConst:      "INT_CONST",
+	OpIntAdd:        "INT_ADD",
+	OpIntSubtract:   "INT_SUBTRACT",
+	OpIntMultiply:   "INT_MULTIPLY",
+	OpIntDivide:     "INT_DIVIDE",
+	OpIntModulo:     "INT_MODULO",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) && opcodeNames[op] != "" {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("OP(%d)", uint8(op))
+}
+
+// binaryOperators maps the arithmetic and comparison opcodes to the
+// operator they implement.
+var binaryOperators = map[string]Opcode{
+	"+":  OpAdd,
+	"-":  OpSubtract,
+	"*":  OpMultiply,
+	"/":  OpDivide,
+	"%":  OpModulo,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<":  OpLess,
+	">":  OpGreater,
+	"<=": OpLessEqual,
+	">=": OpGreaterEqual,
+}
+
+// intOperators maps the arithmetic operators to their opcode on int slots.
+var intOperators = map[string]Opcode{
+	"+": OpIntAdd,
+	"-": OpIntSubtract,
+	"*": OpIntMultiply,
+	"/": OpIntDivide,
+	"%": OpIntModulo,
+}
+
+var operatorSymbols = func() map[Opcode]string {
+	symbols := make(map[Opcode]string, len(binaryOperators))
+	for symbol, op := range binaryOperators {
+		symbols[op] = symbol
+	}
+	return symbols
+}()
@@ -0,0 +1,246 @@
+package up
+
+import core "github.com/KennethanCeyer/up/src/core"
+
+// scope holds the variables of one function, resolved before any code is
+// generated. Variables are hoisted to the function like in the
+// tree-walker: parameters, typed declarations, loop, catch and select
+// variables, and nested declarations are always locals, while a plain
+// assignment only creates a local when no enclosing function defines the
+// name. Locals that nested functions refer to are captured and live in
+// cells, so that every closure sees the same variable.
+type scope struct {
+	parent   *scope
+	locals   []string
+	isLocal  map[string]bool
+	captured map[string]bool
+	// free are the names this function uses from enclosing functions.
+	free map[string]bool
+}
+
+func (s *scope) declare(name string) {
+	if !s.isLocal[name] {
+		s.isLocal[name] = true
+		s.locals = append(s.locals, name)
+	}
+}
+
+// defines reports whether s or an enclosing function has a local name.
+func (s *scope) defines(name string) bool {
+	for ; s != nil; s = s.parent {
+		if s.isLocal[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// use records a reference to name from the body of s.
+func (s *scope) use(name string) {
+	if !s.isLocal[name] && s.parent.defines(name) {
+		s.free[name] = true
+	}
+}
+
+// analyze resolves the variables of decl and of every function nested in
+// it. Field defaults and methods of nested classes count as nested
+// functions.
+func (c *compiler) analyze(decl *core.FuncDeclarationNode, parent *scope) *scope {
+	s := &scope{parent: parent, isLocal: map[string]bool{}, captured: map[string]bool{}, free: map[string]bool{}}
+	for _, param := range decl.Parameters {
+		s.declare(param.Name)
+	}
+	if decl.Receiver != nil {
+		s.declare("this")
+	}
+	for _, stmt := range decl.Body {
+		s.declareIn(stmt)
+	}
+	c.scopes[decl] = s
+
+	for _, stmt := range decl.Body {
+		c.resolveIn(s, stmt)
+	}
+	return s
+}
+
+func (s *scope) declareIn(node core.Node) {
+	switch n := node.(type) {
+	case *core.AssignmentNode:
+		if n.Type != nil || !s.parent.defines(n.VarName) {
+			s.declare(n.VarName)
+		}
+	case *core.ForLoopNode:
+		s.declare(n.Variable)
+		if n.ValueVariable != "" {
+			s.declare(n.ValueVariable)
+		}
+	case *core.TryNode:
+		if n.CatchVar != "" {
+			s.declare(n.CatchVar)
+		}
+	case *core.SelectNode:
+		for _, c := range n.Cases {
+			if assignment, ok := c.Comm.(*core.AssignmentNode); ok {
+				s.declare(assignment.VarName)
+			}
+		}
+	case *core.FuncDeclarationNode:
+		if n.Name != "" && n.Receiver == nil {
+			s.declare(n.Name)
+		}
+		return
+	case *core.ClassDeclarationNode:
+		s.declare(n.Name)
+		return
+	}
+	forEachChild(node, s.declareIn)
+}
+
+func (c *compiler) resolveIn(s *scope, node core.Node) {
+	switch n := node.(type) {
+	case *core.IdentifierNode:
+		s.use(n.Name)
+	case *core.FunctionCallNode:
+		if n.Callee == nil {
+			s.use(n.FunctionName)
+		}
+	case *core.AssignmentNode:
+		s.use(n.VarName)
+	case *core.FuncDeclarationNode:
+		c.nest(s, n)
+		return
+	case *core.ClassDeclarationNode:
+		for _, field := range n.Fields {
+			if field.Default != nil {
+				c.nest(s, c.defaultFunction(field))
+			}
+		}
+		for _, method := range n.Methods {
+			c.nest(s, method)
+		}
+		return
+	}
+	forEachChild(node, func(child core.Node) {
+		c.resolveIn(s, child)
+	})
+}
+
+// nest analyzes a function nested in s and captures the locals of s it
+// uses.
+func (c *compiler) nest(s *scope, decl *core.FuncDeclarationNode) {
+	child := c.analyze(decl, s)
+	for name := range child.free {
+		if s.isLocal[name] {
+			s.captured[name] = true
+		} else {
+			s.free[name] = true
+		}
+	}
+}
+
+// defaultFunction wraps the default of a field in a function without
+// parameters, which is called every time an object is created.
+func (c *compiler) defaultFunction(field *core.FieldNode) *core.FuncDeclarationNode {
+	if decl, ok := c.defaults[field]; ok {
+		return decl
+	}
+	decl := &core.FuncDeclarationNode{
+		ReturnType: &core.TypeNode{Name: "any", Pos: field.Pos},
+		Body:       []core.Node{&core.ReturnNode{Value: field.Default, Pos: field.Pos}},
+		Pos:        field.Pos,
+	}
+	c.defaults[field] = decl
+	return decl
+}
+
+// forEachChild calls fn for the direct sub-nodes of node. Nested
+// functions and classes are left to the callers.
+func forEachChild(node core.Node, fn func(core.Node)) {
+	each := func(nodes []core.Node) {
+		for _, n := range nodes {
+			fn(n)
+		}
+	}
+	switch n := node.(type) {
+	case *core.ListNode:
+		each(n.Elements)
+	case *core.MapNode:
+		for i, key := range n.Keys {
+			fn(key)
+			fn(n.Values[i])
+		}
+	case *core.BinOpNode:
+		fn(n.Left)
+		fn(n.Right)
+	case *core.UnaryOpNode:
+		fn(n.Operand)
+	case *core.FunctionCallNode:
+		if n.Callee != nil {
+			fn(n.Callee)
+		}
+		each(n.Arguments)
+	case *core.MethodCallNode:
+		fn(n.Receiver)
+		each(n.Arguments)
+	case *core.FieldAccessNode:
+		fn(n.Object)
+	case *core.FieldAssignmentNode:
+		fn(n.Object)
+		fn(n.Value)
+	case *core.IndexNode:
+		fn(n.Object)
+		fn(n.Index)
+	case *core.SliceNode:
+		fn(n.Object)
+		if n.Low != nil {
+			fn(n.Low)
+		}
+		if n.High != nil {
+			fn(n.High)
+		}
+	case *core.IndexAssignmentNode:
+		fn(n.Object)
+		fn(n.Index)
+		fn(n.Value)
+	case *core.SendNode:
+		fn(n.Stream)
+		fn(n.Value)
+	case *core.ReceiveNode:
+		fn(n.Stream)
+	case *core.AssignmentNode:
+		fn(n.Value)
+	case *core.ReturnNode:
+		if n.Value != nil {
+			fn(n.Value)
+		}
+	case *core.IfNode:
+		fn(n.Condition)
+		each(n.Then)
+		each(n.Else)
+	case *core.SpawnNode:
+		fn(n.Call)
+	case *core.ForLoopNode:
+		if n.Range != nil {
+			fn(n.Range)
+		} else {
+			fn(n.Iterable)
+		}
+		each(n.Body)
+	case *core.SelectNode:
+		for _, c := range n.Cases {
+			fn(c.Comm)
+			each(c.Body)
+		}
+		each(n.Default)
+		if n.Timeout != nil {
+			fn(n.Timeout)
+		}
+		each(n.TimeoutBody)
+	case *core.ThrowNode:
+		fn(n.Value)
+	case *core.TryNode:
+		each(n.Body)
+		each(n.Catch)
+	}
+}
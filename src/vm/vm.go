@@ -0,0 +1,1066 @@
+package up
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	core "github.com/KennethanCeyer/up/src/core"
+)
+
+// VM executes a compiled module. Values, builtins, types and threads are
+// the ones of the tree-walker, so programs behave the same on both; the
+// global scope is a core.Environment holding the builtins and the
+// top-level classes and functions.
+type VM struct {
+	module  *Module
+	env     *core.Environment
+	globals []core.Value
+	defined []bool
+	types   []typeCheck
+	methods *methodTable
+	// classes maps every class type to the closures computing the
+	// defaults of its fields.
+	classes sync.Map
+	// race checks accesses to cells, the variables threads can share.
+	race bool
+}
+
+// initialStackSize is the number of stack slots a thread starts with.
+const initialStackSize = 1024
+
+const minInt = -1 << (strconv.IntSize - 1)
+
+func New(module *Module, env *core.Environment) *VM {
+	return &VM{module: module, env: env, methods: newMethodTable()}
+}
+
+// Run declares the classes and functions of the program, calls main and
+// waits for the threads it spawned, like core.Run does for the AST. An
+// uncaught up error is returned instead of panicking with it.
+func (vm *VM) Run() (result core.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if errValue, ok := r.(*core.ErrorValue); ok {
+				err = errValue
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	t := vm.newThread(vm.env.Threads().Main())
+	vm.race = t.current.Racing()
+	for _, index := range vm.module.ProgramClasses {
+		class := t.declareClass(vm.module.Classes[index], nil)
+		vm.env.Set(class.Name, class)
+	}
+	for _, index := range vm.module.ProgramFunctions {
+		proto := vm.module.Prototypes[index]
+		function := &Closure{proto: proto}
+		if proto.Receiver != "" {
+			vm.methods.define(proto.Receiver, proto.Name, function)
+		} else {
+			vm.env.Set(proto.Name, function)
+		}
+	}
+	vm.link()
+
+	if mainFunc, ok := vm.env.Get("main"); ok {
+		if main, isFunc := mainFunc.(*Closure); isFunc {
+			result = t.call(main, nil, main.proto.Pos)
+		}
+	}
+
+	// the program ends when main and every thread spawned with up are done.
+	vm.env.Threads().Wait()
+	return result, nil
+}
+
+// link resolves the globals and the declared types once the top-level
+// declarations exist. Globals never change afterwards.
+func (vm *VM) link() {
+	vm.globals = make([]core.Value, len(vm.module.Globals))
+	vm.defined = make([]bool, len(vm.module.Globals))
+	for i, name := range vm.module.Globals {
+		vm.globals[i], vm.defined[i] = vm.env.Get(name)
+	}
+	vm.types = make([]typeCheck, len(vm.module.Types))
+	for i, node := range vm.module.Types {
+		vm.types[i] = vm.newTypeCheck(node)
+	}
+}
+
+// typeCheck is a declared type together with a shortcut for the common
+// case of a plain type name, which accepts exactly one runtime type.
+type typeCheck struct {
+	node    *core.TypeNode
+	any     bool
+	exact   *core.DataType
+	nilable bool
+}
+
+func (vm *VM) newTypeCheck(node *core.TypeNode) typeCheck {
+	check := typeCheck{node: node}
+	switch node.Name {
+	case "any":
+		check.any = true
+		return check
+	case "nil", "func", "list", "map", "stream":
+		return check
+	}
+	if len(node.Params) > 0 {
+		return check
+	}
+	if declared, ok := vm.env.Get(node.Name); ok {
+		if t, ok := declared.(*core.DataType); ok {
+			check.exact = t
+			check.nilable = core.Conforms(node, nil, vm.env)
+		}
+	}
+	return check
+}
+
+// accepts reports whether value surely conforms. When it returns false
+// the caller asks core.CheckType, which decides and reports the error.
+func (c *typeCheck) accepts(value core.Value) bool {
+	if c.any {
+		return true
+	}
+	if c.exact == nil {
+		return false
+	}
+	if c.exact == core.IntType {
+		_, ok := value.(core.Int)
+		return ok
+	}
+	if value == nil {
+		return c.nilable
+	}
+	return value.Type() == c.exact
+}
+
+// checkVariable checks a value stored into a variable with a declared type.
+func (vm *VM) checkVariable(declared *typeCheck, value core.Value, pos core.Position, name string) {
+	if !declared.accepts(value) {
+		core.CheckType(declared.node, value, vm.env, pos, "variable "+name)
+	}
+}
+
+// undefined marks a variable slot that has not been assigned yet. Reading
+// it falls back to the global scope, as a lookup in the tree-walker would.
+type undefined struct{}
+
+func (undefined) Type() *core.DataType { return core.NilType }
+func (undefined) String() string       { return "undefined" }
+
+var unset core.Value = undefined{}
+
+// Closure is a function value of the VM: a prototype together with the
+// cells of the variables it captured.
+type Closure struct {
+	proto    *Prototype
+	upvalues []*cell
+	// this is the receiving value of a method looked up on a value.
+	this core.Value
+}
+
+func (c *Closure) bind(this core.Value) *Closure {
+	return &Closure{proto: c.proto, upvalues: c.upvalues, this: this}
+}
+
+func (c *Closure) Name() string {
+	return c.proto.DisplayName()
+}
+
+func (c *Closure) Arity() int {
+	return len(c.proto.Params)
+}
+
+func (c *Closure) Type() *core.DataType {
+	return core.FuncType
+}
+
+func (c *Closure) String() string {
+	return c.proto.Signature
+}
+
+// cell holds a captured variable, which closures on other threads may read
+// and assign. The value and its declared type are an immutable pair that
+// is replaced as a whole, so no lock is needed.
+type cell struct {
+	state  unsafe.Pointer
+	shadow core.Shadow
+}
+
+type cellState struct {
+	value    core.Value
+	declared *typeCheck
+}
+
+func newCell() *cell {
+	return &cell{state: unsafe.Pointer(&cellState{value: unset})}
+}
+
+func (c *cell) get() (core.Value, *typeCheck) {
+	s := (*cellState)(atomic.LoadPointer(&c.state))
+	return s.value, s.declared
+}
+
+func (c *cell) set(value core.Value, declared *typeCheck) {
+	atomic.StorePointer(&c.state, unsafe.Pointer(&cellState{value: value, declared: declared}))
+}
+
+// assign stores value and keeps the declared type, retrying when another
+// thread replaced the pair in between.
+func (c *cell) assign(value core.Value) {
+	for {
+		old := atomic.LoadPointer(&c.state)
+		next := &cellState{value: value, declared: (*cellState)(old).declared}
+		if atomic.CompareAndSwapPointer(&c.state, old, unsafe.Pointer(next)) {
+			return
+		}
+	}
+}
+
+type methodTable struct {
+	mu      sync.RWMutex
+	methods map[string]map[string]*Closure
+}
+
+func newMethodTable() *methodTable {
+	return &methodTable{methods: make(map[string]map[string]*Closure)}
+}
+
+func (t *methodTable) define(typeName string, name string, method *Closure) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.methods[typeName] == nil {
+		t.methods[typeName] = make(map[string]*Closure)
+	}
+	t.methods[typeName][name] = method
+}
+
+func (t *methodTable) lookup(typeName string, name string) (*Closure, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	method, ok := t.methods[typeName][name]
+	return method, ok
+}
+
+// thread is the execution state of one up thread. Frames take their
+// locals and operand stack from a shared chunk of slots; when the chunk
+// is full a new one is started, so the slices of older frames stay valid.
+type thread struct {
+	vm *VM
+	// current is the scheduled up thread this state belongs to.
+	current *core.Thread
+	values  []core.Value
+	types   []*typeCheck
+	top     int
+	// ints is the chunk the int slots of the frames are taken from.
+	ints   []int
+	intTop int
+}
+
+func (vm *VM) newThread(current *core.Thread) *thread {
+	return &thread{vm: vm, current: current, values: make([]core.Value, initialStackSize), types: make([]*typeCheck, initialStackSize), ints: make([]int, initialStackSize)}
+}
+
+type handler struct {
+	target int
+	sp     int
+}
+
+type frame struct {
+	closure  *Closure
+	locals   []core.Value
+	types    []*typeCheck
+	stack    []core.Value
+	cells    []*cell
+	ints     []int
+	handlers []handler
+	pc, sp   int
+	// depth is the call stack depth of the thread below the frame.
+	depth int
+
+	// the chunk the frame lives in, restored when an error is caught
+	// after callees moved to a new one
+	chunk       []core.Value
+	typeChunk   []*typeCheck
+	chunkTop    int
+	intChunk    []int
+	intChunkTop int
+}
+
+func (f *frame) bind(slot Slot, value core.Value, declared *typeCheck) {
+	if slot.Int {
+		f.ints[slot.Index] = int(value.(core.Int))
+		return
+	}
+	if slot.Cell {
+		f.cells[slot.Index].set(value, declared)
+		return
+	}
+	f.locals[slot.Index] = value
+	f.types[slot.Index] = declared
+}
+
+func (t *thread) call(c *Closure, args []core.Value, pos core.Position) core.Value {
+	// every call is a preemption point.
+	t.current.Preempt(pos)
+
+	proto := c.proto
+	if len(args) != len(proto.Params) {
+		panic(core.NewRuntimeError(pos, "Expected %d arguments but got %d", len(proto.Params), len(args)))
+	}
+
+	values, types, top := t.values, t.types, t.top
+	ints, intTop := t.ints, t.intTop
+	size := proto.NumLocals + proto.MaxStack
+	if top+size > len(values) {
+		n := 2 * len(values)
+		if n < size {
+			n = size
+		}
+		t.values, t.types, t.top = make([]core.Value, n), make([]*typeCheck, n), 0
+	}
+	base := t.top
+	t.top += size
+	f := &frame{
+		closure:   c,
+		locals:    t.values[base : base+proto.NumLocals],
+		types:     t.types[base : base+proto.NumLocals],
+		stack:     t.values[base+proto.NumLocals : t.top],
+		chunk:     t.values,
+		typeChunk: t.types,
+		chunkTop:  t.top,
+	}
+	for i := range f.locals {
+		f.locals[i] = unset
+		f.types[i] = nil
+	}
+	if proto.NumInts > 0 {
+		// int slots are always written before they are read, so they are
+		// not cleared.
+		if t.intTop+proto.NumInts > len(t.ints) {
+			n := 2 * len(t.ints)
+			if n < proto.NumInts {
+				n = proto.NumInts
+			}
+			t.ints, t.intTop = make([]int, n), 0
+		}
+		f.ints = t.ints[t.intTop : t.intTop+proto.NumInts]
+		t.intTop += proto.NumInts
+	}
+	f.intChunk, f.intChunkTop = t.ints, t.intTop
+	if proto.NumCells > 0 {
+		f.cells = make([]*cell, proto.NumCells)
+		for i := range f.cells {
+			f.cells[i] = newCell()
+		}
+	}
+
+	if proto.This.Index >= 0 {
+		f.bind(proto.This, c.this, nil)
+	}
+	for i, arg := range args {
+		declared := &t.vm.types[proto.ParamTypes[i]]
+		if !declared.accepts(arg) {
+			core.CheckType(declared.node, arg, t.vm.env, pos, "argument "+proto.Params[i]+" of "+proto.DisplayName())
+		}
+		f.bind(proto.ParamSlots[i], arg, declared)
+	}
+
+	f.depth = t.current.Enter(proto.DisplayName(), pos)
+	result := t.execute(f)
+	t.current.Unwind(f.depth)
+	t.values, t.types, t.top = values, types, top
+	t.ints, t.intTop = ints, intTop
+	return result
+}
+
+// execute runs a frame to its end. An up error raised inside a try block
+// of the frame continues in its catch block.
+func (t *thread) execute(f *frame) core.Value {
+	for {
+		if result, done := t.resume(f); done {
+			return result
+		}
+	}
+}
+
+func (t *thread) resume(f *frame) (result core.Value, done bool) {
+	if f.closure.proto.HasTry {
+		defer t.catch(f, &done)
+	}
+	return t.run(f), true
+}
+
+func (t *thread) catch(f *frame, done *bool) {
+	if len(f.handlers) == 0 {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	err, ok := r.(*core.ErrorValue)
+	if !ok {
+		panic(r)
+	}
+	h := f.handlers[len(f.handlers)-1]
+	f.handlers = f.handlers[:len(f.handlers)-1]
+	t.values, t.types, t.top = f.chunk, f.typeChunk, f.chunkTop
+	t.ints, t.intTop = f.intChunk, f.intChunkTop
+	t.current.Unwind(f.depth + 1)
+	f.stack[h.sp] = err
+	f.pc, f.sp = h.target, h.sp+1
+	*done = false
+}
+
+// global looks a name up in the global scope at runtime, for variables
+// read before they were assigned.
+func (t *thread) global(name string, called bool, pos core.Position) core.Value {
+	if value, ok := t.vm.env.Get(name); ok {
+		return value
+	}
+	panic(notFound(name, called, pos))
+}
+
+func notFound(name string, called bool, pos core.Position) *core.ErrorValue {
+	if called {
+		return core.NewRuntimeError(pos, "Function %s not found", name)
+	}
+	return core.NewRuntimeError(pos, "Unknown identifier: %s", name)
+}
+
+// callValue calls any callable value: closures, builtins and types.
+func (t *thread) callValue(function core.Value, args []core.Value, callee string, pos core.Position) core.Value {
+	switch fn := function.(type) {
+	case *Closure:
+		return t.call(fn, args, pos)
+	case core.BuiltinFunction:
+		return core.CallBuiltin(t.current, fn, args, pos)
+	case *core.DataType:
+		if fn.Class != nil {
+			return t.construct(fn, args, pos)
+		}
+		return core.ConstructType(t.current, fn, args, pos)
+	default:
+		panic(core.NewRuntimeError(pos, "%s is not a function", callee))
+	}
+}
+
+// callMethod looks the method up in the receiver type's method table, then
+// among the fields of an object, and finally falls back to the builtin of
+// the same name with the receiver as first argument.
+func (t *thread) callMethod(receiver core.Value, name string, args []core.Value, pos core.Position) core.Value {
+	typeName := core.TypeOf(receiver).Name
+	if method, ok := t.vm.methods.lookup(typeName, name); ok {
+		return t.call(method.bind(receiver), args, pos)
+	}
+	if obj, ok := receiver.(*core.Object); ok {
+		if field, ok := obj.Get(name); ok {
+			return t.callValue(field, args, name, pos)
+		}
+	}
+	if builtin, ok := t.vm.env.Get(name); ok {
+		if fn, isBuiltIn := builtin.(core.BuiltinFunction); isBuiltIn {
+			return core.CallBuiltin(t.current, fn, append([]core.Value{receiver}, args...), pos)
+		}
+	}
+	panic(core.NewRuntimeError(pos, "%s has no method %s", typeName, name))
+}
+
+// construct creates an object of a class declared in the module: fields
+// get their defaults, then init or the arguments initialize them.
+func (t *thread) construct(class *core.DataType, args []core.Value, pos core.Position) core.Value {
+	defer core.RethrowAt(pos)
+	obj := core.NewObject(class)
+	if defaults, ok := t.vm.classes.Load(class); ok {
+		for i, d := range defaults.([]*Closure) {
+			if d != nil {
+				obj.Set(class.Class.Decl.Fields[i].Name, t.call(d, nil, pos))
+			}
+		}
+	}
+	if init, ok := t.vm.methods.lookup(class.Name, "init"); ok {
+		t.call(init.bind(obj), args, pos)
+		return obj
+	}
+	class.Class.AssignFields(obj, args)
+	return obj
+}
+
+func (t *thread) declareClass(class *Class, f *frame) *core.DataType {
+	declared := core.NewClassType(class.Decl, t.vm.env)
+	defaults := make([]*Closure, len(class.Defaults))
+	for i, index := range class.Defaults {
+		if index >= 0 {
+			defaults[i] = t.closure(t.vm.module.Prototypes[index], f)
+		}
+	}
+	t.vm.classes.Store(declared, defaults)
+	for _, index := range class.Methods {
+		method := t.closure(t.vm.module.Prototypes[index], f)
+		t.vm.methods.define(declared.Name, method.proto.Name, method)
+	}
+	return declared
+}
+
+func (t *thread) closure(proto *Prototype, f *frame) *Closure {
+	c := &Closure{proto: proto}
+	if len(proto.Captures) > 0 {
+		c.upvalues = make([]*cell, len(proto.Captures))
+		for i, capture := range proto.Captures {
+			if capture.Cell {
+				c.upvalues[i] = f.cells[capture.Index]
+			} else {
+				c.upvalues[i] = f.closure.upvalues[capture.Index]
+			}
+		}
+	}
+	return c
+}
+
+func (t *thread) spawn(function core.Value, args []core.Value, name string, callee string, pos core.Position) {
+	switch fn := function.(type) {
+	case *Closure:
+		if len(args) != len(fn.proto.Params) {
+			panic(core.NewRuntimeError(pos, "Expected %d arguments but got %d", len(fn.proto.Params), len(args)))
+		}
+	case core.BuiltinFunction:
+	default:
+		panic(core.NewRuntimeError(pos, "%s is not a function", callee))
+	}
+	vm := t.vm
+	vm.env.Threads().Spawn(t.current, name, func(thread *core.Thread) {
+		vm.newThread(thread).callValue(function, args, callee, pos)
+	})
+}
+
+func setMapEntry(m *core.Map, key, value core.Value, pos core.Position) {
+	defer core.RethrowAt(pos)
+	m.Set(key, value)
+}
+
+func send(thread *core.Thread, stream *core.Stream, value core.Value, pos core.Position) {
+	defer core.RethrowAt(pos)
+	thread.At(pos)
+	stream.Send(thread, value)
+}
+
+type iteratorKind int
+
+const (
+	rangeIterator iteratorKind = iota
+	listIterator
+	mapIterator
+	streamIterator
+)
+
+// iterator is the hidden state of a for loop, kept on the operand stack
+// while the loop runs.
+type iterator struct {
+	kind   iteratorKind
+	index  int
+	count  int
+	keys   []core.Value
+	values []core.Value
+	stream *core.Stream
+}
+
+func (it *iterator) Type() *core.DataType { return core.AnyType }
+func (it *iterator) String() string       { return "iterator" }
+
+func newIterator(iterable core.Value, twoVariables bool, pos core.Position) *iterator {
+	switch v := iterable.(type) {
+	case *core.List:
+		// iterate over the elements the list had when the loop started.
+		values := v.Snapshot()
+		return &iterator{kind: listIterator, values: values, count: len(values)}
+	case *core.Map:
+		keys, values := v.Snapshot()
+		return &iterator{kind: mapIterator, keys: keys, values: values, count: len(keys)}
+	case *core.Stream:
+		if twoVariables {
+			panic(core.NewRuntimeError(pos, "Stream iteration takes a single variable"))
+		}
+		return &iterator{kind: streamIterator, stream: v}
+	default:
+		panic(core.NewRuntimeError(pos, "Cannot iterate over %s", core.TypeOf(iterable).Name))
+	}
+}
+
+// intError raises the error of int arithmetic the int slot instructions
+// refused: an overflow or a division by zero.
+func intError(op string, left, right int, pos core.Position) {
+	core.BinaryOp(op, core.Int(left), core.Int(right), pos)
+}
+
+// run is the interpreter loop of a frame.
+func (t *thread) run(f *frame) core.Value {
+	vm := t.vm
+	proto := f.closure.proto
+	code := proto.Code
+	constants := vm.module.Constants
+	names := vm.module.Names
+	locals, types, stack, ints := f.locals, f.types, f.stack, f.ints
+	pc, sp := f.pc, f.sp
+
+	for {
+		in := code[pc]
+		pc++
+		switch in.Op {
+		case OpConst:
+			stack[sp] = constants[in.A]
+			sp++
+		case OpNil:
+			stack[sp] = nil
+			sp++
+		case OpPop:
+			sp--
+		case OpDup:
+			n := int(in.A)
+			copy(stack[sp:sp+n], stack[sp-n:sp])
+			sp += n
+
+		case OpLoadLocal:
+			value := locals[in.A]
+			if value == unset {
+				value = t.global(names[in.B], in.C == 1, proto.Lines[pc-1])
+			}
+			stack[sp] = value
+			sp++
+		case OpLoadCell, OpLoadUpvalue:
+			var c *cell
+			if in.Op == OpLoadCell {
+				c = f.cells[in.A]
+			} else {
+				c = f.closure.upvalues[in.A]
+			}
+			value, _ := c.get()
+			if value == unset {
+				value = t.global(names[in.B], in.C == 1, proto.Lines[pc-1])
+			} else if vm.race {
+				t.current.Read(&c.shadow, names[in.B], proto.Lines[pc-1])
+			}
+			stack[sp] = value
+			sp++
+		case OpLoadGlobal:
+			if !vm.defined[in.A] {
+				panic(notFound(vm.module.Globals[in.A], in.C == 1, proto.Lines[pc-1]))
+			}
+			stack[sp] = vm.globals[in.A]
+			sp++
+
+		case OpAssignLocal:
+			sp--
+			value := stack[sp]
+			if declared := types[in.A]; declared != nil {
+				vm.checkVariable(declared, value, proto.Lines[pc-1], names[in.B])
+			}
+			locals[in.A] = value
+		case OpAssignCell, OpAssignUpvalue:
+			sp--
+			value := stack[sp]
+			var c *cell
+			if in.Op == OpAssignCell {
+				c = f.cells[in.A]
+			} else {
+				c = f.closure.upvalues[in.A]
+			}
+			if _, declared := c.get(); declared != nil {
+				vm.checkVariable(declared, value, proto.Lines[pc-1], names[in.B])
+			}
+			c.assign(value)
+			if vm.race {
+				t.current.Write(&c.shadow, names[in.B], proto.Lines[pc-1])
+			}
+		case OpDeclareLocal, OpDeclareCell:
+			sp--
+			value := stack[sp]
+			declared := &vm.types[in.C]
+			vm.checkVariable(declared, value, proto.Lines[pc-1], names[in.B])
+			f.bind(Slot{Cell: in.Op == OpDeclareCell, Index: int(in.A)}, value, declared)
+			if vm.race && in.Op == OpDeclareCell {
+				t.current.Write(&f.cells[in.A].shadow, names[in.B], proto.Lines[pc-1])
+			}
+
+		case OpLoadInt:
+			stack[sp] = core.Int(ints[in.A])
+			sp++
+		case OpStoreInt:
+			sp--
+			i, ok := stack[sp].(core.Int)
+			if !ok {
+				panic(core.NewRuntimeError(proto.Lines[pc-1], "Cannot use %s as int in variable %s", core.TypeOf(stack[sp]).Name, names[in.B]))
+			}
+			ints[in.A] = int(i)
+		case OpIntConst:
+			ints[in.A] = int(constants[in.B].(core.Int))
+		case OpIntAdd:
+			l, r := ints[in.B], ints[in.C]
+			sum := l + r
+			if (sum > l) != (r > 0) {
+				intError("+", l, r, proto.Lines[pc-1])
+			}
+			ints[in.A] = sum
+		case OpIntSubtract:
+			l, r := ints[in.B], ints[in.C]
+			difference := l - r
+			if (difference < l) != (r > 0) {
+				intError("-", l, r, proto.Lines[pc-1])
+			}
+			ints[in.A] = difference
+		case OpIntMultiply:
+			l, r := ints[in.B], ints[in.C]
+			product := l * r
+			if l != 0 && (product/l != r || l == -1 && r == minInt) {
+				intError("*", l, r, proto.Lines[pc-1])
+			}
+			ints[in.A] = product
+		case OpIntDivide:
+			l, r := ints[in.B], ints[in.C]
+			if r == 0 || l == minInt && r == -1 {
+				intError("/", l, r, proto.Lines[pc-1])
+			}
+			ints[in.A] = l / r
+		case OpIntModulo:
+			l, r := ints[in.B], ints[in.C]
+			if r == 0 {
+				intError("%", l, r, proto.Lines[pc-1])
+			}
+			ints[in.A] = l % r
+
+		case OpAdd:
+			sp--
+			left, right := stack[sp-1], stack[sp]
+			if l, ok := left.(core.Int); ok {
+				if r, ok := right.(core.Int); ok {
+					if sum := l + r; (sum > l) == (r > 0) {
+						stack[sp-1] = sum
+						continue
+					}
+				}
+			}
+			stack[sp-1] = core.BinaryOp("+", left, right, proto.Lines[pc-1])
+		case OpSubtract:
+			sp--
+			left, right := stack[sp-1], stack[sp]
+			if l, ok := left.(core.Int); ok {
+				if r, ok := right.(core.Int); ok {
+					if difference := l - r; (difference < l) == (r > 0) {
+						stack[sp-1] = difference
+						continue
+					}
+				}
+			}
+			stack[sp-1] = core.BinaryOp("-", left, right, proto.Lines[pc-1])
+		case OpMultiply:
+			sp--
+			left, right := stack[sp-1], stack[sp]
+			if l, ok := left.(core.Int); ok {
+				if r, ok := right.(core.Int); ok {
+					if product := l * r; l == 0 || product/l == r && !(l == -1 && r == minInt) {
+						stack[sp-1] = product
+						continue
+					}
+				}
+			}
+			stack[sp-1] = core.BinaryOp("*", left, right, proto.Lines[pc-1])
+		case OpDivide, OpModulo:
+			sp--
+			left, right := stack[sp-1], stack[sp]
+			if l, ok := left.(core.Int); ok {
+				if r, ok := right.(core.Int); ok && r != 0 && !(l == minInt && r == -1) {
+					if in.Op == OpDivide {
+						stack[sp-1] = l / r
+					} else {
+						stack[sp-1] = l % r
+					}
+					continue
+				}
+			}
+			stack[sp-1] = core.BinaryOp(operatorSymbols[in.Op], left, right, proto.Lines[pc-1])
+		case OpEqual:
+			sp--
+			stack[sp-1] = core.Bool(core.Equal(stack[sp-1], stack[sp]))
+		case OpNotEqual:
+			sp--
+			stack[sp-1] = core.Bool(!core.Equal(stack[sp-1], stack[sp]))
+		case OpLess, OpGreater, OpLessEqual, OpGreaterEqual:
+			sp--
+			left, right := stack[sp-1], stack[sp]
+			if l, ok := left.(core.Int); ok {
+				if r, ok := right.(core.Int); ok {
+					var result bool
+					switch in.Op {
+					case OpLess:
+						result = l < r
+					case OpGreater:
+						result = l > r
+					case OpLessEqual:
+						result = l <= r
+					default:
+						result = l >= r
+					}
+					stack[sp-1] = core.Bool(result)
+					continue
+				}
+			}
+			stack[sp-1] = core.BinaryOp(operatorSymbols[in.Op], left, right, proto.Lines[pc-1])
+		case OpNot:
+			stack[sp-1] = core.Bool(!core.Truthy(stack[sp-1]))
+		case OpNegate:
+			stack[sp-1] = core.UnaryOp("-", stack[sp-1], proto.Lines[pc-1])
+		case OpBool:
+			stack[sp-1] = core.Bool(core.Truthy(stack[sp-1]))
+
+		case OpJump:
+			// jumping back closes a loop iteration, a preemption point.
+			if int(in.A) < pc {
+				t.current.Preempt(proto.Lines[pc-1])
+			}
+			pc = int(in.A)
+		case OpJumpIfFalse:
+			sp--
+			if !core.Truthy(stack[sp]) {
+				pc = int(in.A)
+			}
+		case OpJumpIfTrue:
+			sp--
+			if core.Truthy(stack[sp]) {
+				pc = int(in.A)
+			}
+
+		case OpList:
+			n := int(in.A)
+			elements := make([]core.Value, n)
+			copy(elements, stack[sp-n:sp])
+			sp -= n
+			stack[sp] = core.NewList(elements)
+			sp++
+		case OpMap:
+			n := int(in.A)
+			m := core.NewMap()
+			for i := sp - 2*n; i < sp; i += 2 {
+				setMapEntry(m, stack[i], stack[i+1], proto.Lines[pc-1])
+			}
+			sp -= 2 * n
+			stack[sp] = m
+			sp++
+		case OpIndex:
+			sp--
+			object, index := stack[sp-1], stack[sp]
+			if list, ok := object.(*core.List); ok {
+				if i, ok := index.(core.Int); ok {
+					if value, ok := list.Get(int(i)); ok {
+						stack[sp-1] = value
+						continue
+					}
+				}
+			}
+			stack[sp-1] = core.Index(object, index, names[in.B], proto.Lines[pc-1])
+		case OpSetIndex:
+			sp -= 3
+			core.SetIndex(stack[sp], stack[sp+1], stack[sp+2], names[in.B], proto.Lines[pc-1])
+		case OpSlice:
+			sp -= 2
+			lowSource, highSource := "", ""
+			if in.A >= 0 {
+				lowSource = names[in.A]
+			}
+			if in.B >= 0 {
+				highSource = names[in.B]
+			}
+			stack[sp-1] = core.Slice(stack[sp-1], stack[sp], stack[sp+1], lowSource, highSource, proto.Lines[pc-1])
+		case OpGetField:
+			stack[sp-1] = core.GetField(stack[sp-1], names[in.A], names[in.B], proto.Lines[pc-1])
+		case OpSetField:
+			sp -= 2
+			core.SetField(stack[sp], names[in.A], stack[sp+1], names[in.B], proto.Lines[pc-1])
+
+		case OpCall:
+			argc := int(in.A)
+			result := t.callValue(stack[sp-argc-1], stack[sp-argc:sp], names[in.B], proto.Lines[pc-1])
+			sp -= argc
+			stack[sp-1] = result
+		case OpCallMethod:
+			argc := int(in.A)
+			result := t.callMethod(stack[sp-argc-1], names[in.B], stack[sp-argc:sp], proto.Lines[pc-1])
+			sp -= argc
+			stack[sp-1] = result
+		case OpSpawn:
+			argc := int(in.A)
+			// arguments are evaluated by the spawning thread so the new
+			// thread never observes later changes of the caller's variables.
+			args := append([]core.Value(nil), stack[sp-argc:sp]...)
+			t.spawn(stack[sp-argc-1], args, names[in.B], names[in.C], proto.Lines[pc-1])
+			sp -= argc + 1
+		case OpReturn:
+			returnType := &vm.types[proto.ReturnType]
+			if in.A == 1 {
+				if returnType.node.Name != "nil" {
+					panic(core.NewRuntimeError(proto.Lines[pc-1], "Missing return value in function %s -> %s", proto.DisplayName(), returnType.node))
+				}
+				return nil
+			}
+			value := stack[sp-1]
+			if !returnType.accepts(value) {
+				core.CheckType(returnType.node, value, vm.env, proto.Lines[pc-1], "return value of "+proto.DisplayName())
+			}
+			return value
+		case OpEnd:
+			if returnType := vm.types[proto.ReturnType].node; returnType.Name != "nil" {
+				panic(core.NewRuntimeError(proto.Pos, "Missing return at end of function %s -> %s", proto.DisplayName(), returnType))
+			}
+			return nil
+		case OpClosure:
+			stack[sp] = t.closure(vm.module.Prototypes[in.A], f)
+			sp++
+		case OpDefineMethod:
+			sp--
+			vm.methods.define(names[in.A], names[in.B], stack[sp].(*Closure))
+		case OpClass:
+			stack[sp] = t.declareClass(vm.module.Classes[in.A], f)
+			sp++
+
+		case OpSend:
+			sp -= 2
+			stream, ok := stack[sp].(*core.Stream)
+			if !ok {
+				panic(core.NewRuntimeError(proto.Lines[pc-1], "Cannot send to non-stream value: %s", names[in.B]))
+			}
+			send(t.current, stream, stack[sp+1], proto.Lines[pc-1])
+		case OpReceive:
+			stream, ok := stack[sp-1].(*core.Stream)
+			if !ok {
+				panic(core.NewRuntimeError(proto.Lines[pc-1], "Cannot receive from non-stream value: %s", names[in.B]))
+			}
+			t.current.At(proto.Lines[pc-1])
+			stack[sp-1], _ = stream.Receive(t.current)
+		case OpThrow:
+			core.Throw(stack[sp-1], proto.Lines[pc-1])
+		case OpTry:
+			f.handlers = append(f.handlers, handler{target: int(in.A), sp: sp})
+		case OpEndTry:
+			f.handlers = f.handlers[:len(f.handlers)-1]
+
+		case OpRange:
+			count, ok := stack[sp-1].(core.Int)
+			if !ok {
+				panic(core.NewRuntimeError(proto.Lines[pc-1], "Expected integer range, but got: %s", core.TypeOf(stack[sp-1]).Name))
+			}
+			stack[sp-1] = &iterator{kind: rangeIterator, count: int(count)}
+		case OpIterate:
+			stack[sp-1] = newIterator(stack[sp-1], in.A == 1, proto.Lines[pc-1])
+		case OpNext:
+			it := stack[sp-1].(*iterator)
+			if it.kind == streamIterator {
+				t.current.At(proto.Lines[pc-1])
+				value, ok := it.stream.Receive(t.current)
+				if !ok {
+					pc = int(in.A)
+					continue
+				}
+				stack[sp] = value
+				sp++
+				continue
+			}
+			if it.index >= it.count {
+				pc = int(in.A)
+				continue
+			}
+			switch it.kind {
+			case rangeIterator:
+				stack[sp] = core.Int(it.index)
+				sp++
+			case listIterator:
+				if in.B == 2 {
+					stack[sp] = core.Int(it.index)
+					sp++
+				}
+				stack[sp] = it.values[it.index]
+				sp++
+			case mapIterator:
+				stack[sp] = it.keys[it.index]
+				sp++
+				if in.B == 2 {
+					stack[sp] = it.values[it.index]
+					sp++
+				}
+			}
+			it.index++
+
+		case OpSelectStream:
+			if _, ok := stack[sp-1].(*core.Stream); !ok {
+				panic(core.NewRuntimeError(proto.Lines[pc-1], "Cannot select on non-stream value: %s", names[in.A]))
+			}
+		case OpSelectSend:
+			core.CheckSend(stack[sp-2].(*core.Stream), stack[sp-1], proto.Lines[pc-1])
+		case OpSelect:
+			var value core.Value
+			pc, sp, value = t.selectArm(proto.Selects[in.A], stack, sp, proto.Lines[pc-1])
+			if pc < 0 {
+				// an arm that stores the received value
+				pc = -pc - 1
+				stack[sp] = value
+				sp++
+			}
+
+		default:
+			panic(core.NewRuntimeError(proto.Lines[pc-1], "Unknown instruction %s", in.Op))
+		}
+	}
+}
+
+// selectArm waits for one arm of a select statement and returns where the
+// chosen arm continues and the operand stack without the operands. Arms
+// that store the received value are returned as -(target+1).
+func (t *thread) selectArm(table *SelectTable, stack []core.Value, sp int, pos core.Position) (int, int, core.Value) {
+	operands := len(table.Arms)
+	for _, arm := range table.Arms {
+		if arm.Send {
+			operands++
+		}
+	}
+	if table.HasTimeout {
+		operands++
+	}
+	base := sp - operands
+
+	cases := make([]core.SelectCase, len(table.Arms))
+	i := base
+	for j, arm := range table.Arms {
+		cases[j].Stream = stack[i].(*core.Stream)
+		i++
+		if arm.Send {
+			cases[j].Send = true
+			cases[j].Value = stack[i]
+			i++
+		}
+	}
+	var timeout *time.Duration
+	if table.HasTimeout {
+		d := core.SelectTimeoutDuration(stack[i], table.TimeoutSource, pos)
+		timeout = &d
+	}
+
+	t.current.At(pos)
+	chosen, value := core.Select(t.current, cases, timeout, table.HasDefault, pos)
+	switch chosen {
+	case core.SelectTimeout:
+		return table.TimeoutTarget, base, nil
+	case core.SelectDefault:
+		return table.DefaultTarget, base, nil
+	}
+	arm := table.Arms[chosen]
+	if arm.Assign {
+		return -arm.Target - 1, base, value
+	}
+	return arm.Target, base, nil
+}
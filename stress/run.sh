@@ -0,0 +1,22 @@
+#!/bin/sh
+# Runs every stress program on 1, 4 and 16 processors and compares its
+# output with the .out file next to it. Usage: stress/run.sh [up binary];
+# pass "up -vm" to stress the bytecode VM.
+
+up=${1:-"go run ."}
+dir=$(dirname "$0")
+status=0
+
+for file in "$dir"/*.up; do
+    expected="${file%.up}.out"
+    for procs in 1 4 16; do
+        if $up -debug=false -procs "$procs" "$file" 2>&1 | cmp -s - "$expected"; then
+            result=ok
+        else
+            result=FAIL
+            status=1
+        fi
+        printf '%-24s procs=%-3d %s\n' "$(basename "$file")" "$procs" "$result"
+    done
+done
+exit $status